@@ -0,0 +1,11 @@
+data "rpaas_instance_status" "my_rpaas" {
+  service_name = "rpaasv2-be"
+  instance     = "my-rpaas"
+}
+
+check "my_rpaas_healthy" {
+  assert {
+    condition     = data.rpaas_instance_status.my_rpaas.ready
+    error_message = "Pods of my-rpaas are not ready: ${join(", ", [for p in data.rpaas_instance_status.my_rpaas.pods : "${p.name} (${p.phase})" if !p.ready])}"
+  }
+}
@@ -0,0 +1,8 @@
+import {
+  to = rpaas_block.http
+  id = provider::rpaas::build_id("rpaas_block", {
+    service_name = "rpaasv2-be"
+    instance     = "my-rpaas"
+    name         = "http"
+  })
+}
@@ -0,0 +1,3 @@
+output "certificate_fingerprint" {
+  value = provider::rpaas::cert_fingerprint(rpaas_certificate.example.certificate)
+}
@@ -0,0 +1,7 @@
+resource "rpaas_block" "server" {
+  service_name = "rpaasv2-be"
+  instance     = "my-rpaas"
+
+  name    = "server"
+  content = "add_header X-Message ${provider::rpaas::nginx_escape(var.message)};"
+}
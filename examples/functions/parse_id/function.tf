@@ -0,0 +1,7 @@
+locals {
+  route = provider::rpaas::parse_id("rpaas_route", "rpaasv2-be::my-rpaas::my-rpaas.example.com::/app")
+}
+
+output "route_path" {
+  value = local.route.path # "/app"
+}
@@ -1,4 +1,10 @@
 terraform import rpaas_acl.resource_name "service::instance::host::port"
 
 # example
-terraform import rpaas_acl.myacl "rpaasv2-be::my-rpaas::example.com::443"
\ No newline at end of file
+terraform import rpaas_acl.myacl "rpaasv2-be::my-rpaas::example.com::443"
+
+# ":" and "%" in any component must be percent-encoded as "%3A" and "%25"
+terraform import rpaas_acl.myipv6acl "rpaasv2-be::my-rpaas::2001%3Adb8%3A%3A1::80"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_acl.myacl "service_name=rpaasv2-be,instance=my-rpaas,host=example.com"
\ No newline at end of file
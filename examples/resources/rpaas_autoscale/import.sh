@@ -1,4 +1,7 @@
 terraform import rpaas_autoscale.resource_name "service::instance"
 
 # example
-terraform import rpaas_autoscale.myautoscale "rpaasv2-be::my-rpaas"
\ No newline at end of file
+terraform import rpaas_autoscale.myautoscale "rpaasv2-be::my-rpaas"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_autoscale.myautoscale "service_name=rpaasv2-be,instance=my-rpaas"
\ No newline at end of file
@@ -1,4 +1,7 @@
 terraform import rpaas_block.resource_name "service::instance::name"
 
 # example
-terraform import rpaas_block.myblock "rpaasv2-be::my-rpaas::http"
\ No newline at end of file
+terraform import rpaas_block.myblock "rpaasv2-be::my-rpaas::http"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_block.myblock "service_name=rpaasv2-be,instance=my-rpaas,name=http"
\ No newline at end of file
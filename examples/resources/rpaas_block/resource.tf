@@ -51,3 +51,25 @@ resource "rpaas_block" "example_lua" {
   name    = "lua-server"
   content = file("script.lua")
 }
+
+resource "rpaas_block" "example_template" {
+  service_name = "rpaasv2-be"
+  instance     = "my-rpaas"
+
+  name     = "server"
+  template = <<-EOF
+    location /api {
+    {{ template "headers" . }}
+      proxy_pass http://{{ .upstream }};
+    }
+  EOF
+
+  includes = {
+    headers = "  more_set_headers 'X-Environment: {{ .environment }}';"
+  }
+
+  vars = {
+    environment = "production"
+    upstream    = "my-app.apps.tsuru.io"
+  }
+}
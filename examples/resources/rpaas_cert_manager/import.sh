@@ -2,4 +2,7 @@ terraform import rpaas_cert_manager.resource_name "service::instance::issuer"
 # issuer == <resource name>.<resource kind>.<resource group>
 
 # example
-terraform import rpaas_cert_manager.mycertmanager "rpaasv2-be::my-rpaas::issuer.ClusterIssuer.example.com"
\ No newline at end of file
+terraform import rpaas_cert_manager.mycertmanager "rpaasv2-be::my-rpaas::issuer.ClusterIssuer.example.com"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_cert_manager.mycertmanager "service_name=rpaasv2-be,instance=my-rpaas,certificate_name=example.com"
\ No newline at end of file
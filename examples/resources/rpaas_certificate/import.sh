@@ -1,4 +1,7 @@
 terraform import rpaas_certificate.resource_name "service::instance::name"
 
 # example
-terraform import rpaas_certificate.mycertificate "rpaasv2-be::my-rpaas::example.com"
\ No newline at end of file
+terraform import rpaas_certificate.mycertificate "rpaasv2-be::my-rpaas::example.com"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_certificate.mycertificate "service_name=rpaasv2-be,instance=my-rpaas,name=example.com"
\ No newline at end of file
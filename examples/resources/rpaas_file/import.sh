@@ -1,4 +1,7 @@
 terraform import rpaas_file.resource_name "service::instance::filename"
 
 # example
-terraform import rpaas_file.myfile "rpaasv2-be::my-rpaas::example.txt"
\ No newline at end of file
+terraform import rpaas_file.myfile "rpaasv2-be::my-rpaas::example.txt"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_file.myfile "service_name=rpaasv2-be,instance=my-rpaas,name=example.txt"
\ No newline at end of file
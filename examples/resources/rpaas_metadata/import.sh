@@ -0,0 +1,7 @@
+terraform import rpaas_metadata.resource_name "service::instance"
+
+# example
+terraform import rpaas_metadata.mymetadata "rpaasv2-be::my-rpaas"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_metadata.mymetadata "service_name=rpaasv2-be,instance=my-rpaas"
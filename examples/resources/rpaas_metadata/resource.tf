@@ -0,0 +1,24 @@
+resource "rpaas_metadata" "example" {
+  service_name = "rpaasv2-be"
+  instance     = "my-rpaas"
+
+  labels = {
+    team        = "platform"
+    cost-center = "1234"
+  }
+
+  annotations = {
+    "example.com/owner" = "platform@example.com"
+  }
+}
+
+# removes any other label or annotation of the instance
+resource "rpaas_metadata" "authoritative" {
+  service_name = "rpaasv2-be"
+  instance     = "my-other-rpaas"
+  mode         = "authoritative"
+
+  labels = {
+    team = "platform"
+  }
+}
@@ -1,4 +1,10 @@
 terraform import rpaas_route.resource_name "service::instance::path"
 
 # example
-terraform import rpaas_route.myroute "rpaasv2-be::my-rpaas::/"
\ No newline at end of file
+terraform import rpaas_route.myroute "rpaasv2-be::my-rpaas::/"
+
+# ":" and "%" in any component must be percent-encoded as "%3A" and "%25"
+terraform import rpaas_route.myregexroute "rpaasv2-be::my-rpaas::~ ^/api%3A%3Av1"
+
+# attributes may be used instead of the ID, as long as a single object matches them
+terraform import rpaas_route.myroute "service_name=rpaasv2-be,instance=my-rpaas,path=/"
\ No newline at end of file
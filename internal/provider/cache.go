@@ -0,0 +1,162 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cachedReadTimeout bounds the fetches shared by concurrent reads, which do
+// not stop when the reads that joined them are canceled.
+const cachedReadTimeout = 5 * time.Minute
+
+// instanceCache keeps the objects listed from each RPaaS instance, so that
+// reading many resources of the same instance, as on a refresh, lists them
+// once. Concurrent reads of the same list share a single request. A new
+// cache is created each time the provider is configured, which Terraform
+// does once per plan, apply or refresh walk, so lists read while planning
+// are never reused when applying. Within a walk, entries are dropped by any
+// write to their instance made through this provider; changes made by
+// others during the walk are not seen.
+type instanceCache struct {
+	mu          sync.Mutex
+	entries     map[cacheKey]interface{}
+	generations map[instanceKey]uint64
+	group       singleflight.Group
+}
+
+type instanceKey struct {
+	service  string
+	instance string
+}
+
+type cacheKey struct {
+	instanceKey
+	kind string
+}
+
+// fetchResult is the result of a fetch shared by concurrent reads. The body
+// of its response is kept apart, so that each read gets a response it can
+// read on its own.
+type fetchResult struct {
+	value    interface{}
+	response *http.Response
+	body     []byte
+}
+
+func newFetchResult(value interface{}, response *http.Response) *fetchResult {
+	result := &fetchResult{value: value, response: response}
+	if response != nil && response.Body != nil {
+		result.body, _ = io.ReadAll(response.Body)
+		response.Body.Close()
+	}
+	return result
+}
+
+// Response returns a copy of the response of the fetch, or nil.
+func (r *fetchResult) Response() *http.Response {
+	if r == nil || r.response == nil {
+		return nil
+	}
+
+	response := *r.response
+	response.Body = io.NopCloser(bytes.NewReader(r.body))
+	return &response
+}
+
+func newInstanceCache() *instanceCache {
+	return &instanceCache{
+		entries:     make(map[cacheKey]interface{}),
+		generations: make(map[instanceKey]uint64),
+	}
+}
+
+// cachedRead returns the kind of objects (e.g. "blocks") of the instance,
+// calling fetch when they are not cached. Errors are not cached. A nil
+// cache always calls fetch. The response of the fetch is returned along, for
+// rpaasRetry to tell retryable errors apart; it is nil for cached objects.
+//
+// The fetch shared by concurrent reads runs on a context detached from the
+// one of the read that started it, so canceling a read does not fail the
+// other ones. Each read still returns as soon as its own context is done.
+func cachedRead[T any](ctx context.Context, c *instanceCache, serviceName, instance, kind string, fetch func(ctx context.Context) (T, *http.Response, error)) (T, *http.Response, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	key := cacheKey{instanceKey: instanceKey{service: serviceName, instance: instance}, kind: kind}
+
+	c.mu.Lock()
+	if v, found := c.entries[key]; found {
+		c.mu.Unlock()
+		return v.(T), nil, nil
+	}
+	generation := c.generations[key.instanceKey]
+	c.mu.Unlock()
+
+	// reads started after a write never join the ones started before it
+	flight := buildID(serviceName, instance, kind, strconv.FormatUint(generation, 10))
+
+	ch := c.group.DoChan(flight, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cachedReadTimeout)
+		defer cancel()
+
+		v, response, err := fetch(fetchCtx)
+		if err != nil {
+			return newFetchResult(nil, response), err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// the instance was written while fetching: the result may be stale
+		if c.generations[key.instanceKey] == generation {
+			c.entries[key] = v
+		}
+
+		return newFetchResult(v, response), nil
+	})
+
+	var zero T
+	select {
+	case result := <-ch:
+		fetched, _ := result.Val.(*fetchResult)
+		if result.Err != nil {
+			return zero, fetched.Response(), result.Err
+		}
+		return fetched.value.(T), fetched.Response(), nil
+
+	case <-ctx.Done():
+		return zero, nil, ctx.Err()
+	}
+}
+
+// Invalidate drops every object cached for the instance. It must be called
+// after each write to the instance.
+func (c *instanceCache) Invalidate(serviceName, instance string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ik := instanceKey{service: serviceName, instance: instance}
+	c.generations[ik]++
+
+	for key := range c.entries {
+		if key.instanceKey == ik {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+func TestCachedRead(t *testing.T) {
+	cache := newInstanceCache()
+
+	var calls int32
+	fetch := func(ctx context.Context) ([]string, *http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return []string{"a", "b"}, nil, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, result)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// other kinds and instances are cached on their own
+	cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "routes", fetch)
+	cachedRead(context.Background(), cache, "rpaasv2", "other-rpaas", "blocks", fetch)
+	cachedRead(context.Background(), cache, "rpaasv2-be", "my-rpaas", "blocks", fetch)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	cache.Invalidate("rpaasv2", "my-rpaas")
+
+	cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+	cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "routes", fetch)
+	cachedRead(context.Background(), cache, "rpaasv2", "other-rpaas", "blocks", fetch)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+}
+
+func TestCachedRead_Errors(t *testing.T) {
+	cache := newInstanceCache()
+
+	var calls int
+	fetch := func(ctx context.Context) ([]string, *http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, nil, errors.New("boom")
+		}
+		return []string{"a"}, nil, nil
+	}
+
+	_, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+	assert.EqualError(t, err, "boom")
+
+	result, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, result)
+	assert.Equal(t, 2, calls)
+
+	// a nil cache always fetches
+	cachedRead(context.Background(), (*instanceCache)(nil), "rpaasv2", "my-rpaas", "blocks", fetch)
+	assert.Equal(t, 3, calls)
+}
+
+func TestCachedRead_Response(t *testing.T) {
+	cache := newInstanceCache()
+
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]string, *http.Response, error) {
+		<-release
+		response := &http.Response{StatusCode: http.StatusConflict, Body: io.NopCloser(strings.NewReader("event locked"))}
+		return nil, response, errors.New("event locked")
+	}
+
+	// every read joining the fetch gets the response, with its own body
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, response, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+			assert.EqualError(t, err, "event locked")
+			require.NotNil(t, response)
+			assert.Equal(t, http.StatusConflict, response.StatusCode)
+			body, err := io.ReadAll(response.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "event locked", string(body))
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// cached objects come without a response
+	_, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "routes", func(ctx context.Context) ([]string, *http.Response, error) {
+		return []string{"a"}, &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	require.NoError(t, err)
+	result, response, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "routes", fetch)
+	require.NoError(t, err)
+	assert.Nil(t, response)
+	assert.Equal(t, []string{"a"}, result)
+}
+
+func TestCachedRead_WriteWhileFetching(t *testing.T) {
+	cache := newInstanceCache()
+
+	var calls int
+	result, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", func(ctx context.Context) ([]string, *http.Response, error) {
+		calls++
+		cache.Invalidate("rpaasv2", "my-rpaas")
+		return []string{"stale"}, nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stale"}, result)
+
+	// the result fetched before the write finished is not kept
+	result, _, err = cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", func(ctx context.Context) ([]string, *http.Response, error) {
+		calls++
+		return []string{"fresh"}, nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fresh"}, result)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCachedRead_CanceledRead(t *testing.T) {
+	cache := newInstanceCache()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]string, *http.Response, error) {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		return []string{"a"}, nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, _, err := cachedRead(ctx, cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+		canceled <- err
+	}()
+	<-started
+
+	joined := make(chan []string, 1)
+	go func() {
+		result, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+		assert.NoError(t, err)
+		joined <- result
+	}()
+
+	// the canceled read returns while the fetch it started goes on
+	cancel()
+	select {
+	case err := <-canceled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled read did not return")
+	}
+
+	close(release)
+	select {
+	case result := <-joined:
+		assert.Equal(t, []string{"a"}, result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("joined read did not return")
+	}
+
+	result, _, err := cachedRead(context.Background(), cache, "rpaasv2", "my-rpaas", "blocks", fetch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, result)
+}
+
+func TestRouteReadsShareListCalls(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var routes []types.Route
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls[r.Method+" "+r.URL.Path]++
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/resources/my-rpaas/route":
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"paths": routes})
+
+		case r.Method == http.MethodPost && r.URL.Path == "/resources/my-rpaas/route":
+			r.ParseForm()
+			routes = append(routes, types.Route{Path: r.Form.Get("path"), Destination: r.Form.Get("destination")})
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for i := 0; i < 20; i++ {
+		routes = append(routes, types.Route{Path: fmt.Sprintf("/path-%d", i), Destination: "app.tsuru.io"})
+	}
+
+	t.Setenv("RPAAS_MAX_REQUESTS_PER_SECOND", "")
+	t.Setenv("RPAAS_MAX_CONCURRENT_REQUESTS", "")
+
+	opts, err := getProviderConfigOpts(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"rpaas_url": server.URL,
+	}))
+	require.NoError(t, err)
+
+	provider := &rpaasProvider{opts: opts, cache: newInstanceCache()}
+
+	readRoute := func(path string) *schema.ResourceData {
+		d := resourceRpaasRoute().TestResourceData()
+		d.SetId(buildRpaasRouteID("rpaasv2", "my-rpaas", "", path))
+		diags := resourceRpaasRouteRead(context.Background(), d, provider)
+		require.False(t, diags.HasError(), "%v", diags)
+		return d
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := readRoute(fmt.Sprintf("/path-%d", i))
+			assert.Equal(t, "app.tsuru.io", d.Get("destination"))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"GET /resources/my-rpaas/route": 1}, calls)
+
+	d := resourceRpaasRoute().TestResourceData()
+	d.Set("service_name", "rpaasv2")
+	d.Set("instance", "my-rpaas")
+	d.Set("path", "/new")
+	d.Set("destination", "new.tsuru.io")
+	diags := resourceRpaasRouteCreate(context.Background(), d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	// the write drops the cached routes, so the created route is found
+	assert.Equal(t, "new.tsuru.io", d.Get("destination"))
+	assert.Equal(t, "new.tsuru.io", readRoute("/new").Get("destination"))
+	assert.Equal(t, map[string]int{
+		"GET /resources/my-rpaas/route":  2,
+		"POST /resources/my-rpaas/route": 1,
+	}, calls)
+}
+
+func TestProviderConfigure_NewCache(t *testing.T) {
+	t.Setenv("RPAAS_MAX_REQUESTS_PER_SECOND", "")
+	t.Setenv("RPAAS_MAX_CONCURRENT_REQUESTS", "")
+
+	configure := func() *rpaasProvider {
+		meta, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+			"rpaas_url": "http://rpaas.example.com",
+		}))
+		require.False(t, diags.HasError(), "%v", diags)
+		return meta.(*rpaasProvider)
+	}
+
+	planning := configure()
+	_, _, err := cachedRead(context.Background(), planning.cache, "rpaasv2", "my-rpaas", "routes", func(ctx context.Context) ([]types.Route, *http.Response, error) {
+		return []types.Route{{Path: "/"}}, nil, nil
+	})
+	require.NoError(t, err)
+
+	// lists read while planning are not reused when applying
+	applying := configure()
+	routes, _, err := cachedRead(context.Background(), applying.cache, "rpaasv2", "my-rpaas", "routes", func(ctx context.Context) ([]types.Route, *http.Response, error) {
+		return nil, nil, nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, routes)
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"net/http"
+	"sync"
+)
+
+// clientPool keeps an API client for each service instance. All
+// of them share a single transport, and so its connections: only the Tsuru
+// proxy routing differs between clients.
+type clientPool struct {
+	once      sync.Once
+	transport http.RoundTripper
+
+	mu      sync.Mutex
+	clients map[instanceKey]*rpaasAPI
+}
+
+func (p *clientPool) Client(opts *ProviderConfigOptions, service, instance string) *rpaasAPI {
+	p.once.Do(func() {
+		p.transport = newSharedTransport(opts)
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := instanceKey{service: service, instance: instance}
+	if client, found := p.clients[key]; found {
+		return client
+	}
+
+	if p.clients == nil {
+		p.clients = make(map[instanceKey]*rpaasAPI)
+	}
+
+	client := &rpaasAPI{
+		APIClient: newAutogeneratedClient(opts, p.transport, service, instance),
+		instance:  instance,
+	}
+	p.clients[key] = client
+
+	return client
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/autogenerated"
+)
+
+// newConnCountingServer returns a fake Tsuru API serving the autoscale of
+// any instance through the service proxy, and the number of connections it
+// has accepted so far.
+func newConnCountingServer(t testing.TB) (*httptest.Server, func() int64) {
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/1.0/services/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server, func() int64 { return atomic.LoadInt64(&conns) }
+}
+
+func newTestClientPoolProvider(target string) *rpaasProvider {
+	return &rpaasProvider{
+		opts: &ProviderConfigOptions{
+			TsuruTarget: target,
+			TsuruToken:  "my-token",
+		},
+	}
+}
+
+func getAutoscale(t testing.TB, client *autogenerated.APIClient, instance string) {
+	_, _, err := client.RpaasApi.GetAutoscale(context.Background(), instance).Execute()
+	require.NoError(t, err)
+}
+
+func TestClientPool(t *testing.T) {
+	server, conns := newConnCountingServer(t)
+	provider := newTestClientPoolProvider(server.URL)
+
+	client := provider.Client("rpaasv2", "my-rpaas")
+	assert.Same(t, client, provider.Client("rpaasv2", "my-rpaas"))
+	assert.NotSame(t, client, provider.Client("rpaasv2", "other-rpaas"))
+	assert.NotSame(t, client, provider.Client("rpaasv2-be", "my-rpaas"))
+
+	for i := 0; i < 10; i++ {
+		for _, instance := range []string{"my-rpaas", "other-rpaas", "another-rpaas"} {
+			getAutoscale(t, provider.Client("rpaasv2", instance).APIClient, instance)
+		}
+	}
+
+	// every client sends its requests over the same connection
+	assert.Equal(t, int64(1), conns())
+}
+
+func TestClientPool_ProxyRouting(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	}))
+	defer server.Close()
+
+	provider := newTestClientPoolProvider(server.URL)
+
+	getAutoscale(t, provider.Client("rpaasv2", "my-rpaas").APIClient, "my-rpaas")
+	getAutoscale(t, provider.Client("rpaasv2-be", "other-rpaas").APIClient, "other-rpaas")
+
+	assert.Equal(t, []string{
+		"/1.0/services/rpaasv2/proxy/my-rpaas?callback=/resources/my-rpaas/autoscale",
+		"/1.0/services/rpaasv2-be/proxy/other-rpaas?callback=/resources/other-rpaas/autoscale",
+	}, paths)
+}
+
+// BenchmarkAutogeneratedClient compares the pool against creating a client
+// for each call, reporting how many connections each request needed.
+func BenchmarkAutogeneratedClient(b *testing.B) {
+	instances := make([]string, 10)
+	for i := range instances {
+		instances[i] = fmt.Sprintf("rpaas-%d", i)
+	}
+
+	b.Run("pool", func(b *testing.B) {
+		server, conns := newConnCountingServer(b)
+		provider := newTestClientPoolProvider(server.URL)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			instance := instances[i%len(instances)]
+			getAutoscale(b, provider.Client("rpaasv2", instance).APIClient, instance)
+		}
+		b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+	})
+
+	b.Run("per-call", func(b *testing.B) {
+		server, conns := newConnCountingServer(b)
+		provider := newTestClientPoolProvider(server.URL)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			instance := instances[i%len(instances)]
+			opts := *provider.opts
+			opts.TsuruService, opts.TsuruInstance = "rpaasv2", instance
+			getAutoscale(b, getAutogeneratedClient(&opts), instance)
+		}
+		b.ReportMetric(float64(conns())/float64(b.N), "conns/op")
+	})
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	_ datasource.DataSource              = (*instanceStatusDataSource)(nil)
+	_ datasource.DataSourceWithConfigure = (*instanceStatusDataSource)(nil)
+)
+
+func newInstanceStatusDataSource() datasource.DataSource {
+	return &instanceStatusDataSource{}
+}
+
+type instanceStatusDataSource struct {
+	provider *rpaasProvider
+}
+
+type instanceStatusModel struct {
+	ID             types.String             `tfsdk:"id"`
+	ServiceName    types.String             `tfsdk:"service_name"`
+	Instance       types.String             `tfsdk:"instance"`
+	Ready          types.Bool               `tfsdk:"ready"`
+	ConfigRevision types.String             `tfsdk:"config_revision"`
+	Pods           []instanceStatusPodModel `tfsdk:"pods"`
+}
+
+type instanceStatusPodModel struct {
+	Name           types.String `tfsdk:"name"`
+	IP             types.String `tfsdk:"ip"`
+	Phase          types.String `tfsdk:"phase"`
+	Ready          types.Bool   `tfsdk:"ready"`
+	Restarts       types.Int64  `tfsdk:"restarts"`
+	ConfigRevision types.String `tfsdk:"config_revision"`
+	StartTime      types.String `tfsdk:"start_time"`
+}
+
+func (ds *instanceStatusDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_instance_status"
+}
+
+func (ds *instanceStatusDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Status of the pods of an RPaaS instance and the nginx configuration they run.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "The ID of this data source.",
+			},
+			"service_name": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
+			},
+			"instance": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
+			},
+			"ready": schema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether the instance has pods, all of them running and ready.",
+			},
+			"config_revision": schema.StringAttribute{
+				Computed:    true,
+				Description: "Revision of the nginx configuration the pods run, null while they run different ones.",
+			},
+			"pods": schema.ListNestedAttribute{
+				Computed:    true,
+				Description: "Pods of the instance, sorted by name.",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							Computed:    true,
+							Description: "Name of the pod.",
+						},
+						"ip": schema.StringAttribute{
+							Computed:    true,
+							Description: "IP address of the pod.",
+						},
+						"phase": schema.StringAttribute{
+							Computed:    true,
+							Description: "Phase of the pod, such as `Running` or `Pending`, or `Terminating` and `Errored`.",
+						},
+						"ready": schema.BoolAttribute{
+							Computed:    true,
+							Description: "Whether the nginx container is ready.",
+						},
+						"restarts": schema.Int64Attribute{
+							Computed:    true,
+							Description: "Number of restarts of the nginx container.",
+						},
+						"config_revision": schema.StringAttribute{
+							Computed:    true,
+							Description: "Revision of the nginx configuration the pod runs: the pod template hash in its name, which changes with any change to the configuration, files or certificates, or null for pods not named after one.",
+						},
+						"start_time": schema.StringAttribute{
+							Computed:    true,
+							Description: "Creation time of the pod, in RFC 3339 format.",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (ds *instanceStatusDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	provider, ok := req.ProviderData.(*rpaasProvider)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("Expected *rpaasProvider, got %T. This is a bug in the provider.", req.ProviderData))
+		return
+	}
+
+	ds.provider = provider
+}
+
+func (ds *instanceStatusDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	ctx = withHTTPLogging(ctx)
+	ctx, span := startOperationSpan(ctx, ds.provider, "rpaas_instance_status", "read")
+	defer func() {
+		if resp.Diagnostics.HasError() {
+			span.SetStatus(codes.Error, resp.Diagnostics.Errors()[0].Summary())
+		}
+		span.End()
+	}()
+
+	if ds.provider == nil {
+		resp.Diagnostics.AddError("Unconfigured provider", "The provider must be configured before reading rpaas_instance_status. This is a bug in the provider.")
+		return
+	}
+
+	var data instanceStatusModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	serviceName, instance := data.ServiceName.ValueString(), data.Instance.ValueString()
+	if serviceName == "" {
+		serviceName = ds.provider.opts.DefaultServiceName
+	}
+	if instance == "" {
+		instance = ds.provider.opts.DefaultInstance
+	}
+
+	if serviceName == "" {
+		resp.Diagnostics.AddError("Missing service_name", "\"service_name\" is required: set it on the data source or \"default_service_name\" on the provider")
+	}
+	if instance == "" {
+		resp.Diagnostics.AddError("Missing instance", "\"instance\" is required: set it on the data source or \"default_instance\" on the provider")
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	span.SetAttributes(attribute.String("rpaas.service", serviceName), attribute.String("rpaas.instance", instance))
+
+	tflog.Info(ctx, "Read rpaas_instance_status", map[string]interface{}{
+		"service":  serviceName,
+		"instance": instance,
+	})
+
+	pods, _, err := ds.provider.Client(serviceName, instance).Pods(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("Unable to get instance status", fmt.Sprintf("Unable to get pods of instance %s: %v", instance, err))
+		return
+	}
+
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	data.ID = types.StringValue(buildID(serviceName, instance))
+	data.ServiceName = types.StringValue(serviceName)
+	data.Instance = types.StringValue(instance)
+	data.Pods = make([]instanceStatusPodModel, 0, len(pods))
+
+	revisions := make(map[string]bool)
+	ready := len(pods) > 0
+	for _, p := range pods {
+		revision := podRevision(p)
+		revisions[revision] = true
+		ready = ready && p.Ready && p.Status == "Running"
+
+		configRevision := types.StringNull()
+		if revision != "" {
+			configRevision = types.StringValue(revision)
+		}
+
+		startTime := types.StringNull()
+		if !p.CreatedAt.IsZero() {
+			startTime = types.StringValue(p.CreatedAt.UTC().Format(time.RFC3339))
+		}
+
+		data.Pods = append(data.Pods, instanceStatusPodModel{
+			Name:           types.StringValue(p.Name),
+			IP:             types.StringValue(p.IP),
+			Phase:          types.StringValue(p.Status),
+			Ready:          types.BoolValue(p.Ready),
+			Restarts:       types.Int64Value(int64(p.Restarts)),
+			ConfigRevision: configRevision,
+			StartTime:      startTime,
+		})
+	}
+
+	// pods not named after a pod template hash run an unknown revision
+	data.ConfigRevision = types.StringNull()
+	if len(revisions) == 1 && !revisions[""] {
+		for revision := range revisions {
+			data.ConfigRevision = types.StringValue(revision)
+		}
+	}
+	data.Ready = types.BoolValue(ready)
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
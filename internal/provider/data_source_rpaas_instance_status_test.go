@@ -0,0 +1,196 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rpaastypes "github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+// readTestInstanceStatus reads rpaas_instance_status through the provider
+// server, configured with the provider attributes given.
+func readTestInstanceStatus(t *testing.T, providerConfig, config map[string]string) (map[string]tftypes.Value, []*tfprotov6.Diagnostic) {
+	t.Helper()
+
+	for _, env := range []string{"RPAAS_URL", "RPAAS_USER", "RPAAS_PASSWORD", "TSURU_TARGET", "TSURU_TOKEN"} {
+		t.Setenv(env, "")
+	}
+
+	ctx := context.Background()
+	providerServer, err := ProviderServer(ctx)
+	require.NoError(t, err)
+	server := providerServer()
+
+	providerSchema, err := server.GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	require.NoError(t, err)
+	require.Empty(t, providerSchema.Diagnostics)
+
+	configured, err := server.ConfigureProvider(ctx, &tfprotov6.ConfigureProviderRequest{
+		Config: testDynamicValue(t, providerSchema.Provider.ValueType().(tftypes.Object), providerConfig),
+	})
+	require.NoError(t, err)
+	require.Empty(t, configured.Diagnostics)
+
+	dataSourceType := providerSchema.DataSourceSchemas["rpaas_instance_status"].ValueType().(tftypes.Object)
+	read, err := server.ReadDataSource(ctx, &tfprotov6.ReadDataSourceRequest{
+		TypeName: "rpaas_instance_status",
+		Config:   testDynamicValue(t, dataSourceType, config),
+	})
+	require.NoError(t, err)
+	if len(read.Diagnostics) > 0 {
+		return nil, read.Diagnostics
+	}
+
+	state, err := read.State.Unmarshal(dataSourceType)
+	require.NoError(t, err)
+
+	var attributes map[string]tftypes.Value
+	require.NoError(t, state.As(&attributes))
+	return attributes, nil
+}
+
+// testDynamicValue returns an object with the string attributes given, and
+// the other ones null.
+func testDynamicValue(t *testing.T, objectType tftypes.Object, values map[string]string) *tfprotov6.DynamicValue {
+	t.Helper()
+
+	attributes := make(map[string]tftypes.Value)
+	for name, attributeType := range objectType.AttributeTypes {
+		attributes[name] = tftypes.NewValue(attributeType, nil)
+		if v, ok := values[name]; ok {
+			attributes[name] = tftypes.NewValue(attributeType, v)
+		}
+	}
+
+	value, err := tfprotov6.NewDynamicValue(objectType, tftypes.NewValue(objectType, attributes))
+	require.NoError(t, err)
+	return &value
+}
+
+func TestDataSourceRpaasInstanceStatus(t *testing.T) {
+	createdAt := time.Date(2024, 5, 10, 13, 0, 0, 0, time.FixedZone("BRT", -3*60*60))
+	provider, _ := newTestPodsServer(t, []rpaastypes.Pod{
+		{Name: "my-rpaas-bbbbb-x2k9z", IP: "10.0.0.2", Status: "Running", Ready: true, CreatedAt: createdAt},
+		{Name: "my-rpaas-bbbbb-a7f3q", IP: "10.0.0.1", Status: "Running", Ready: true, Restarts: 2, CreatedAt: createdAt},
+	})
+
+	providerConfig := map[string]string{
+		"rpaas_url":            provider.opts.URL,
+		"rpaas_user":           provider.opts.Username,
+		"rpaas_password":       provider.opts.Password,
+		"default_service_name": "rpaasv2",
+	}
+
+	status, diags := readTestInstanceStatus(t, providerConfig, map[string]string{"instance": "my-rpaas"})
+	require.Empty(t, diags)
+
+	assert.True(t, tftypes.NewValue(tftypes.String, "rpaasv2::my-rpaas").Equal(status["id"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "rpaasv2").Equal(status["service_name"]))
+	assert.True(t, tftypes.NewValue(tftypes.Bool, true).Equal(status["ready"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "bbbbb").Equal(status["config_revision"]))
+
+	var pods []tftypes.Value
+	require.NoError(t, status["pods"].As(&pods))
+	require.Len(t, pods, 2)
+
+	var pod map[string]tftypes.Value
+	require.NoError(t, pods[0].As(&pod))
+	assert.True(t, tftypes.NewValue(tftypes.String, "my-rpaas-bbbbb-a7f3q").Equal(pod["name"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "10.0.0.1").Equal(pod["ip"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "Running").Equal(pod["phase"]))
+	assert.True(t, tftypes.NewValue(tftypes.Bool, true).Equal(pod["ready"]))
+	assert.True(t, tftypes.NewValue(tftypes.Number, 2).Equal(pod["restarts"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "bbbbb").Equal(pod["config_revision"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, "2024-05-10T16:00:00Z").Equal(pod["start_time"]))
+}
+
+func TestDataSourceRpaasInstanceStatus_RollingOut(t *testing.T) {
+	provider, _ := newTestPodsServer(t, []rpaastypes.Pod{
+		{Name: "my-rpaas-aaaaa-1", Status: "Running", Ready: true},
+		{Name: "my-rpaas-bbbbb-1", Status: "Pending"},
+	})
+
+	providerConfig := map[string]string{
+		"rpaas_url":      provider.opts.URL,
+		"rpaas_user":     provider.opts.Username,
+		"rpaas_password": provider.opts.Password,
+	}
+
+	status, diags := readTestInstanceStatus(t, providerConfig, map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas"})
+	require.Empty(t, diags)
+	assert.True(t, tftypes.NewValue(tftypes.Bool, false).Equal(status["ready"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, nil).Equal(status["config_revision"]))
+
+	_, diags = readTestInstanceStatus(t, providerConfig, map[string]string{"service_name": "rpaasv2"})
+	require.Len(t, diags, 1)
+	assert.Equal(t, "Missing instance", diags[0].Summary)
+}
+
+func TestDataSourceRpaasInstanceStatus_NoPodTemplateHash(t *testing.T) {
+	provider, _ := newTestPodsServer(t, []rpaastypes.Pod{
+		{Name: "my-rpaas", Status: "Running", Ready: true},
+	})
+
+	providerConfig := map[string]string{
+		"rpaas_url":      provider.opts.URL,
+		"rpaas_user":     provider.opts.Username,
+		"rpaas_password": provider.opts.Password,
+	}
+
+	// the pod is not named after a pod template hash, which does not make it less ready
+	status, diags := readTestInstanceStatus(t, providerConfig, map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas"})
+	require.Empty(t, diags)
+	assert.True(t, tftypes.NewValue(tftypes.Bool, true).Equal(status["ready"]))
+	assert.True(t, tftypes.NewValue(tftypes.String, nil).Equal(status["config_revision"]))
+
+	var pods []tftypes.Value
+	require.NoError(t, status["pods"].As(&pods))
+	require.Len(t, pods, 1)
+
+	var pod map[string]tftypes.Value
+	require.NoError(t, pods[0].As(&pod))
+	assert.True(t, tftypes.NewValue(tftypes.String, nil).Equal(pod["config_revision"]))
+}
+
+func TestDataSourceRpaasInstanceStatus_Unconfigured(t *testing.T) {
+	var resp datasource.ReadResponse
+	newInstanceStatusDataSource().Read(context.Background(), datasource.ReadRequest{}, &resp)
+	require.True(t, resp.Diagnostics.HasError())
+	assert.Equal(t, "Unconfigured provider", resp.Diagnostics.Errors()[0].Summary())
+}
+
+func TestAccRpaasInstanceStatusDataSource(t *testing.T) {
+	server, _ := setupTestRpaasServer(t)
+	defer server.Stop()
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+data "rpaas_instance_status" "my-rpaas" {
+  service_name = "rpaasv2"
+  instance     = "my-rpaas"
+}
+`,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("data.rpaas_instance_status.my-rpaas", "id", "rpaasv2::my-rpaas"),
+					resource.TestCheckResourceAttrSet("data.rpaas_instance_status.my-rpaas", "ready"),
+					resource.TestCheckResourceAttrSet("data.rpaas_instance_status.my-rpaas", "pods.#"),
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// resolveServiceInstanceDefaults plans the provider default_service_name and
+// default_instance on resources omitting service_name or instance, so the
+// state always holds the resolved values and changing the defaults replaces
+// the resources using them.
+func resolveServiceInstanceDefaults(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	var defaults map[string]string
+	if provider, ok := meta.(*rpaasProvider); ok && provider.opts != nil {
+		defaults = map[string]string{
+			"service_name": provider.opts.DefaultServiceName,
+			"instance":     provider.opts.DefaultInstance,
+		}
+	}
+
+	rawConfig := d.GetRawConfig()
+	for _, attribute := range []string{"service_name", "instance"} {
+		configured := !rawConfig.IsNull() && !rawConfig.GetAttr(attribute).IsNull()
+		if rawConfig.IsNull() {
+			// legacy callers do not send the raw config
+			configured = d.Get(attribute).(string) != ""
+		}
+
+		if configured {
+			continue
+		}
+
+		value := defaults[attribute]
+		if value == "" {
+			return fmt.Errorf("%q is required: set it on the resource or \"default_%s\" on the provider", attribute, attribute)
+		}
+
+		if d.Get(attribute).(string) == value {
+			continue
+		}
+
+		if err := d.SetNew(attribute, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
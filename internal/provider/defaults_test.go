@@ -0,0 +1,123 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveServiceInstanceDefaults(t *testing.T) {
+	provider := &rpaasProvider{opts: &ProviderConfigOptions{
+		DefaultServiceName: "rpaasv2-be",
+		DefaultInstance:    "my-rpaas",
+	}}
+
+	existing := &terraform.InstanceState{
+		ID: "rpaasv2-be::my-rpaas::example.com::443",
+		Attributes: map[string]string{
+			"id":           "rpaasv2-be::my-rpaas::example.com::443",
+			"service_name": "rpaasv2-be",
+			"instance":     "my-rpaas",
+			"host":         "example.com",
+			"port":         "443",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		provider      *rpaasProvider
+		state         *terraform.InstanceState
+		config        map[string]cty.Value
+		expected      map[string]string
+		requiresNew   bool
+		expectedError string
+	}{
+		{
+			name:     "defaults on create",
+			provider: provider,
+			config:   map[string]cty.Value{},
+			expected: map[string]string{"service_name": "rpaasv2-be", "instance": "my-rpaas"},
+		},
+		{
+			name:     "explicit values take precedence",
+			provider: provider,
+			config:   map[string]cty.Value{"instance": cty.StringVal("other-rpaas")},
+			expected: map[string]string{"service_name": "rpaasv2-be", "instance": "other-rpaas"},
+		},
+		{
+			name:     "no changes when the defaults match the state",
+			provider: provider,
+			state:    existing,
+			config:   map[string]cty.Value{},
+		},
+		{
+			name: "changing the default replaces the resource",
+			provider: &rpaasProvider{opts: &ProviderConfigOptions{
+				DefaultServiceName: "rpaasv2-be",
+				DefaultInstance:    "other-rpaas",
+			}},
+			state:       existing,
+			config:      map[string]cty.Value{},
+			expected:    map[string]string{"instance": "other-rpaas"},
+			requiresNew: true,
+		},
+		{
+			name:          "missing without defaults",
+			provider:      &rpaasProvider{opts: &ProviderConfigOptions{DefaultServiceName: "rpaasv2-be"}},
+			config:        map[string]cty.Value{},
+			expectedError: `"instance" is required: set it on the resource or "default_instance" on the provider`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resourceRpaasACL()
+
+			attributes := map[string]cty.Value{}
+			for name, attribute := range r.CoreConfigSchema().Attributes {
+				attributes[name] = cty.NullVal(attribute.Type)
+			}
+			attributes["host"] = cty.StringVal("example.com")
+			attributes["port"] = cty.NumberIntVal(443)
+			for k, v := range tt.config {
+				attributes[k] = v
+			}
+
+			// as sent by Terraform on PlanResourceChange
+			state := &terraform.InstanceState{}
+			if tt.state != nil {
+				state = tt.state.DeepCopy()
+			}
+			state.RawConfig = cty.ObjectVal(attributes)
+
+			config := terraform.NewResourceConfigShimmed(state.RawConfig, r.CoreConfigSchema())
+			diff, err := r.SimpleDiff(context.Background(), state, config, tt.provider)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			for k, v := range tt.expected {
+				require.Contains(t, diff.Attributes, k)
+				assert.Equal(t, v, diff.Attributes[k].New)
+			}
+
+			if tt.state != nil && len(tt.expected) == 0 {
+				assert.True(t, diff == nil || diff.Empty(), "expected no changes, got %v", diff)
+			}
+
+			if tt.requiresNew {
+				assert.True(t, diff.RequiresNew())
+			}
+		})
+	}
+}
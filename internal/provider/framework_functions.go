@@ -0,0 +1,273 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// resourceIDFormats are the ID formats handled by the parse_id and build_id
+// functions, by resource type.
+var resourceIDFormats = map[string]resourceIDFormat{
+	"rpaas_acl":          rpaasACLID,
+	"rpaas_autoscale":    rpaasAutoscaleID,
+	"rpaas_block":        rpaasBlockID,
+	"rpaas_cert_manager": rpaasCertManagerID,
+	"rpaas_certificate":  rpaasCertificateID,
+	"rpaas_file":         rpaasFileID,
+	"rpaas_metadata":     rpaasMetadataID,
+	"rpaas_route":        rpaasRouteID,
+}
+
+func resourceIDFormatOf(resourceType string) (resourceIDFormat, *function.FuncError) {
+	format, ok := resourceIDFormats[resourceType]
+	if !ok {
+		names := make([]string, 0, len(resourceIDFormats))
+		for name := range resourceIDFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return format, function.NewArgumentFuncError(0, fmt.Sprintf("Unknown resource type %q. Valid types are: %s", resourceType, strings.Join(names, ", ")))
+	}
+
+	return format, nil
+}
+
+func providerFunctions() []func() function.Function {
+	return []func() function.Function{
+		func() function.Function { return &parseIDFunction{} },
+		func() function.Function { return &buildIDFunction{} },
+		func() function.Function { return &nginxEscapeFunction{} },
+		func() function.Function { return &certFingerprintFunction{} },
+	}
+}
+
+var (
+	_ function.Function = (*parseIDFunction)(nil)
+	_ function.Function = (*buildIDFunction)(nil)
+	_ function.Function = (*nginxEscapeFunction)(nil)
+	_ function.Function = (*certFingerprintFunction)(nil)
+)
+
+type parseIDFunction struct{}
+
+func (f *parseIDFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "parse_id"
+}
+
+func (f *parseIDFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Parse the ID of an RPaaS resource",
+		Description: "Parses the ID of a resource of the given type, like `rpaas_route`, into a map of its attributes. IDs in legacy formats are accepted as well, and the attributes they lack are left out of the map. The supported types are `rpaas_acl`, `rpaas_autoscale`, `rpaas_block`, `rpaas_cert_manager`, `rpaas_certificate`, `rpaas_file`, `rpaas_metadata` and `rpaas_route`, and the attributes are named after the ones of the resource.",
+		Parameters: []function.Parameter{
+			function.StringParameter{Name: "resource_type", Description: "Type of the resource, like `rpaas_block`."},
+			function.StringParameter{Name: "id", Description: "ID of the resource."},
+		},
+		Return: function.MapReturn{ElementType: types.StringType},
+	}
+}
+
+func (f *parseIDFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var resourceType, id string
+	resp.Error = req.Arguments.Get(ctx, &resourceType, &id)
+	if resp.Error != nil {
+		return
+	}
+
+	format, ferr := resourceIDFormatOf(resourceType)
+	if ferr != nil {
+		resp.Error = ferr
+		return
+	}
+
+	attributes, err := format.Parse(id)
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(1, err.Error())
+		return
+	}
+
+	resp.Error = resp.Result.Set(ctx, attributes)
+}
+
+type buildIDFunction struct{}
+
+func (f *buildIDFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "build_id"
+}
+
+func (f *buildIDFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Build the ID of an RPaaS resource",
+		Description: "Builds the ID of a resource of the given type, like `rpaas_route`, from a map of its attributes, as returned by `parse_id`. Optional attributes, like `server_name`, may be left out or blank. Missing required attributes, unknown attributes and non-numeric ACL ports are rejected.",
+		Parameters: []function.Parameter{
+			function.StringParameter{Name: "resource_type", Description: "Type of the resource, like `rpaas_block`."},
+			function.MapParameter{Name: "attributes", Description: "Attributes the ID is built from.", ElementType: types.StringType},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *buildIDFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var resourceType string
+	var attributes map[string]string
+	resp.Error = req.Arguments.Get(ctx, &resourceType, &attributes)
+	if resp.Error != nil {
+		return
+	}
+
+	format, ferr := resourceIDFormatOf(resourceType)
+	if ferr != nil {
+		resp.Error = ferr
+		return
+	}
+
+	if err := validateIDAttributes(format, attributes); err != nil {
+		resp.Error = function.NewArgumentFuncError(1, err.Error())
+		return
+	}
+
+	resp.Error = resp.Result.Set(ctx, format.Build(attributes))
+}
+
+func validateIDAttributes(format resourceIDFormat, attributes map[string]string) error {
+	for _, name := range format.Required {
+		if attributes[name] == "" {
+			return fmt.Errorf("Missing attribute %q", name)
+		}
+	}
+
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	valid := append(append([]string{}, format.Required...), format.Optional...)
+	for _, name := range names {
+		if !containsString(valid, name) {
+			return fmt.Errorf("Unexpected attribute %q. Valid attributes are: %s", name, strings.Join(valid, ", "))
+		}
+	}
+
+	if port, ok := attributes["port"]; ok {
+		if _, err := strconv.Atoi(port); err != nil {
+			return fmt.Errorf("Invalid port %q: must be an integer", port)
+		}
+	}
+
+	return nil
+}
+
+type nginxEscapeFunction struct{}
+
+func (f *nginxEscapeFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "nginx_escape"
+}
+
+func (f *nginxEscapeFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Quote a string as an nginx directive argument",
+		Description: "Returns the value enclosed in double quotes, escaping backslashes, double quotes, newlines, carriage returns and tabs, so it can be used as a single argument of an nginx directive. Variables, like `$host`, are still expanded by nginx. The template delimiters `{{` and `}}` are escaped too, as the RPaaS operator renders the content of `rpaas_block` as a Go template; the content of `rpaas_route` is not a template, so do not use it there for values containing them.",
+		Parameters: []function.Parameter{
+			function.StringParameter{Name: "value", Description: "Value to be quoted."},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *nginxEscapeFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var value string
+	resp.Error = req.Arguments.Get(ctx, &value)
+	if resp.Error != nil {
+		return
+	}
+
+	resp.Error = resp.Result.Set(ctx, nginxEscape(value))
+}
+
+// nginxEscaper also escapes the delimiters of the Go template blocks are
+// rendered with, as actions printing them.
+var nginxEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"{{", `{{"{{"}}`,
+	"}}", `{{"}}"}}`,
+)
+
+func nginxEscape(value string) string {
+	return `"` + nginxEscaper.Replace(value) + `"`
+}
+
+type certFingerprintFunction struct{}
+
+func (f *certFingerprintFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "cert_fingerprint"
+}
+
+func (f *certFingerprintFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "SHA-256 fingerprint of a certificate",
+		Description: "Returns the SHA-256 fingerprint of the first certificate of a PEM bundle, as colon separated uppercase hex bytes, the format of `openssl x509 -noout -fingerprint -sha256`.",
+		Parameters: []function.Parameter{
+			function.StringParameter{Name: "pem", Description: "PEM encoded certificate, like the `certificate` of `rpaas_certificate`."},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *certFingerprintFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var certPEM string
+	resp.Error = req.Arguments.Get(ctx, &certPEM)
+	if resp.Error != nil {
+		return
+	}
+
+	fingerprint, err := certFingerprint(certPEM)
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+
+	resp.Error = resp.Result.Set(ctx, fingerprint)
+}
+
+func certFingerprint(certPEM string) (string, error) {
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return "", fmt.Errorf("No certificate found in the PEM data")
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return "", fmt.Errorf("Could not parse certificate: %w", err)
+		}
+
+		sum := sha256.Sum256(block.Bytes)
+		hex := make([]string, len(sum))
+		for i, b := range sum {
+			hex[i] = fmt.Sprintf("%02X", b)
+		}
+		return strings.Join(hex, ":"), nil
+	}
+}
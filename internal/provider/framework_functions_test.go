@@ -0,0 +1,196 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var stringMap = tftypes.Map{ElementType: tftypes.String}
+
+func callTestFunction(t *testing.T, name string, args ...tftypes.Value) (tftypes.Value, *tfprotov6.FunctionError) {
+	t.Helper()
+
+	ctx := context.Background()
+	providerServer, err := ProviderServer(ctx)
+	require.NoError(t, err)
+	server := providerServer()
+
+	// as Terraform does, the schema is read before calling functions
+	providerSchema, err := server.GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	require.NoError(t, err)
+	require.Empty(t, providerSchema.Diagnostics)
+	require.Contains(t, providerSchema.Functions, name)
+
+	arguments := make([]*tfprotov6.DynamicValue, len(args))
+	for i, arg := range args {
+		value, err := tfprotov6.NewDynamicValue(arg.Type(), arg)
+		require.NoError(t, err)
+		arguments[i] = &value
+	}
+
+	resp, err := server.CallFunction(ctx, &tfprotov6.CallFunctionRequest{Name: name, Arguments: arguments})
+	require.NoError(t, err)
+	if resp.Error != nil {
+		return tftypes.Value{}, resp.Error
+	}
+
+	result, err := resp.Result.Unmarshal(providerSchema.Functions[name].Return.Type)
+	require.NoError(t, err)
+	return result, nil
+}
+
+func stringMapValue(attributes map[string]string) tftypes.Value {
+	values := make(map[string]tftypes.Value, len(attributes))
+	for k, v := range attributes {
+		values[k] = tftypes.NewValue(tftypes.String, v)
+	}
+	return tftypes.NewValue(stringMap, values)
+}
+
+func TestFunctionParseAndBuildID(t *testing.T) {
+	tests := []struct {
+		resourceType string
+		id           string
+		attributes   map[string]string
+	}{
+		{"rpaas_acl", "rpaasv2::my-rpaas::my-host.example.com::443", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "host": "my-host.example.com", "port": "443"}},
+		{"rpaas_autoscale", "rpaasv2::my-rpaas", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas"}},
+		{"rpaas_block", "rpaasv2::my-rpaas::server", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "server_name": "", "name": "server"}},
+		{"rpaas_block", "rpaasv2::my-rpaas::my-rpaas.example.com::server", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "server_name": "my-rpaas.example.com", "name": "server"}},
+		{"rpaas_cert_manager", "rpaasv2::my-rpaas::my-issuer::my-cert", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "issuer": "my-issuer", "certificate_name": "my-cert"}},
+		{"rpaas_certificate", "rpaasv2::my-rpaas::my-cert", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "name": "my-cert"}},
+		{"rpaas_file", "rpaasv2::my-rpaas::index.html", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "name": "index.html"}},
+		{"rpaas_metadata", "rpaasv2::other-rpaas", map[string]string{"service_name": "rpaasv2", "instance": "other-rpaas"}},
+		{"rpaas_route", "rpaasv2::my-rpaas::/app", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "server_name": "", "path": "/app"}},
+		{"rpaas_route", "rpaasv2::my-rpaas::my-rpaas.example.com::/app", map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "server_name": "my-rpaas.example.com", "path": "/app"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			parsed, ferr := callTestFunction(t, "parse_id", tftypes.NewValue(tftypes.String, tt.resourceType), tftypes.NewValue(tftypes.String, tt.id))
+			require.Nil(t, ferr)
+			assert.True(t, stringMapValue(tt.attributes).Equal(parsed), "unexpected attributes: %s", parsed)
+
+			built, ferr := callTestFunction(t, "build_id", tftypes.NewValue(tftypes.String, tt.resourceType), parsed)
+			require.Nil(t, ferr)
+			assert.True(t, tftypes.NewValue(tftypes.String, tt.id).Equal(built), "unexpected ID: %s", built)
+		})
+	}
+}
+
+func TestFunctionParseAndBuildID_Errors(t *testing.T) {
+	_, ferr := callTestFunction(t, "parse_id", tftypes.NewValue(tftypes.String, "rpaas_instance"), tftypes.NewValue(tftypes.String, "rpaasv2::my-rpaas"))
+	require.NotNil(t, ferr)
+	assert.Contains(t, ferr.Text, `Unknown resource type "rpaas_instance"`)
+
+	_, ferr = callTestFunction(t, "parse_id", tftypes.NewValue(tftypes.String, "rpaas_acl"), tftypes.NewValue(tftypes.String, "rpaasv2::my-rpaas"))
+	require.NotNil(t, ferr)
+	assert.Equal(t, int64(1), *ferr.FunctionArgument)
+	assert.Contains(t, ferr.Text, `Could not parse id "rpaasv2::my-rpaas"`)
+
+	_, ferr = callTestFunction(t, "build_id", tftypes.NewValue(tftypes.String, "rpaas_route"), stringMapValue(map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas"}))
+	require.NotNil(t, ferr)
+	assert.Contains(t, ferr.Text, `Missing attribute "path"`)
+
+	_, ferr = callTestFunction(t, "build_id", tftypes.NewValue(tftypes.String, "rpaas_file"), stringMapValue(map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "name": "index.html", "path": "/app"}))
+	require.NotNil(t, ferr)
+	assert.Contains(t, ferr.Text, `Unexpected attribute "path". Valid attributes are: service_name, instance, name`)
+
+	_, ferr = callTestFunction(t, "build_id", tftypes.NewValue(tftypes.String, "rpaas_acl"), stringMapValue(map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "host": "my-host.example.com", "port": "https"}))
+	require.NotNil(t, ferr)
+	assert.Contains(t, ferr.Text, `Invalid port "https": must be an integer`)
+}
+
+func TestFunctionNginxEscape(t *testing.T) {
+	value := "say \"hi\"\tto\n{ C:\\ }"
+
+	result, ferr := callTestFunction(t, "nginx_escape", tftypes.NewValue(tftypes.String, value))
+	require.Nil(t, ferr)
+
+	var escaped string
+	require.NoError(t, result.As(&escaped))
+	assert.Equal(t, `"say \"hi\"\tto\n{ C:\\ }"`, escaped)
+
+	// the quoted value is a single token of the directive
+	assert.Equal(t, []string{"add_header", "X-Greeting", escaped, ";"}, nginxTokens("add_header X-Greeting "+escaped+";"))
+}
+
+func TestNginxEscape_TemplateDelimiters(t *testing.T) {
+	escaped := nginxEscape(`{{ .Instance }} }}}`)
+	assert.Equal(t, `"{{"{{"}} .Instance {{"}}"}} {{"}}"}}}"`, escaped)
+
+	// the block content is rendered by the operator as a Go template
+	tpl, err := template.New("server").Parse("add_header X-Template " + escaped + ";")
+	require.NoError(t, err)
+	var rendered strings.Builder
+	require.NoError(t, tpl.Execute(&rendered, nil))
+	assert.Equal(t, `add_header X-Template "{{ .Instance }} }}}";`, rendered.String())
+}
+
+func TestFunctionCertFingerprint(t *testing.T) {
+	certPEM, keyPEM, _ := generateTestClientCertificate(t)
+	block, _ := pem.Decode([]byte(certPEM))
+	require.NotNil(t, block)
+
+	sum := sha256.Sum256(block.Bytes)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+
+	// keys before the certificate are skipped
+	result, ferr := callTestFunction(t, "cert_fingerprint", tftypes.NewValue(tftypes.String, keyPEM+certPEM))
+	require.Nil(t, ferr)
+	assert.True(t, tftypes.NewValue(tftypes.String, strings.Join(hex, ":")).Equal(result), "unexpected fingerprint: %s", result)
+
+	_, ferr = callTestFunction(t, "cert_fingerprint", tftypes.NewValue(tftypes.String, keyPEM))
+	require.NotNil(t, ferr)
+	assert.Contains(t, ferr.Text, "No certificate found in the PEM data")
+}
+
+func TestAccFunctions(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+locals {
+  route = provider::rpaas::parse_id("rpaas_route", "rpaasv2::my-rpaas::/app")
+}
+
+output "path" {
+  value = local.route.path
+}
+
+output "id" {
+  value = provider::rpaas::build_id("rpaas_route", merge(local.route, { server_name = "my-rpaas.example.com" }))
+}
+
+output "escaped" {
+  value = provider::rpaas::nginx_escape("hello \"world\"")
+}
+`,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckOutput("path", "/app"),
+					resource.TestCheckOutput("id", "rpaasv2::my-rpaas::my-rpaas.example.com::/app"),
+					resource.TestCheckOutput("escaped", `"hello \"world\""`),
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	fwprovider "github.com/hashicorp/terraform-plugin-framework/provider"
+	fwschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+var (
+	_ fwprovider.Provider              = (*frameworkProvider)(nil)
+	_ fwprovider.ProviderWithFunctions = (*frameworkProvider)(nil)
+)
+
+// frameworkProvider serves the resources written with the plugin framework,
+// muxed with the SDKv2 provider. Both share the provider configuration: the
+// schema is derived from the SDKv2 one, and the configured RPaaS provider is
+// handed over through configured, so either half may be configured first.
+type frameworkProvider struct {
+	sdk        *schema.Provider
+	configured *configuredProvider
+}
+
+func newFrameworkProvider(sdk *schema.Provider, configured *configuredProvider) *frameworkProvider {
+	return &frameworkProvider{sdk: sdk, configured: configured}
+}
+
+func (p *frameworkProvider) Metadata(ctx context.Context, req fwprovider.MetadataRequest, resp *fwprovider.MetadataResponse) {
+	resp.TypeName = "rpaas"
+	resp.Version = Version
+}
+
+func (p *frameworkProvider) Schema(ctx context.Context, req fwprovider.SchemaRequest, resp *fwprovider.SchemaResponse) {
+	attributes, err := frameworkProviderAttributes(p.sdk.Schema)
+	if err != nil {
+		resp.Diagnostics.AddError("Could not build the provider schema", err.Error())
+		return
+	}
+
+	resp.Schema = fwschema.Schema{Attributes: attributes}
+}
+
+func (p *frameworkProvider) Configure(ctx context.Context, req fwprovider.ConfigureRequest, resp *fwprovider.ConfigureResponse) {
+	config, err := newFrameworkProviderConfig(p.sdk.Schema, req.Config.Raw)
+	if err != nil {
+		resp.Diagnostics.AddError("Could not read the provider configuration", err.Error())
+		return
+	}
+
+	provider, err := p.configured.configure(ctx, config)
+	if err != nil {
+		resp.Diagnostics.AddError("Could not configure the provider", err.Error())
+		return
+	}
+
+	resp.DataSourceData = provider
+	resp.ResourceData = provider
+}
+
+func (p *frameworkProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return nil
+}
+
+func (p *frameworkProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
+	return []func() datasource.DataSource{
+		newInstanceStatusDataSource,
+	}
+}
+
+func (p *frameworkProvider) Functions(ctx context.Context) []func() function.Function {
+	return providerFunctions()
+}
+
+// frameworkProviderAttributes converts the SDKv2 provider schema, as the
+// schemas of muxed providers must be identical.
+func frameworkProviderAttributes(sdkSchema map[string]*schema.Schema) (map[string]fwschema.Attribute, error) {
+	names := make([]string, 0, len(sdkSchema))
+	for name := range sdkSchema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attributes := make(map[string]fwschema.Attribute, len(sdkSchema))
+	for _, name := range names {
+		s := sdkSchema[name]
+		switch s.Type {
+		case schema.TypeString:
+			attributes[name] = fwschema.StringAttribute{Description: s.Description, Required: s.Required, Optional: s.Optional, Sensitive: s.Sensitive, DeprecationMessage: s.Deprecated}
+		case schema.TypeInt:
+			attributes[name] = fwschema.Int64Attribute{Description: s.Description, Required: s.Required, Optional: s.Optional, Sensitive: s.Sensitive, DeprecationMessage: s.Deprecated}
+		case schema.TypeFloat:
+			attributes[name] = fwschema.Float64Attribute{Description: s.Description, Required: s.Required, Optional: s.Optional, Sensitive: s.Sensitive, DeprecationMessage: s.Deprecated}
+		case schema.TypeBool:
+			attributes[name] = fwschema.BoolAttribute{Description: s.Description, Required: s.Required, Optional: s.Optional, Sensitive: s.Sensitive, DeprecationMessage: s.Deprecated}
+		default:
+			return nil, fmt.Errorf("Could not convert provider attribute %q: unsupported type %s", name, s.Type)
+		}
+	}
+
+	return attributes, nil
+}
+
+// frameworkProviderConfig reads the configuration received by the plugin
+// framework the way the SDKv2 does: unset attributes fall back to their
+// defaults (environment variables included) and zero values count as unset.
+type frameworkProviderConfig map[string]interface{}
+
+func newFrameworkProviderConfig(sdkSchema map[string]*schema.Schema, raw tftypes.Value) (frameworkProviderConfig, error) {
+	values := make(map[string]tftypes.Value)
+	if !raw.IsNull() && raw.IsKnown() {
+		if err := raw.As(&values); err != nil {
+			return nil, err
+		}
+	}
+
+	config := make(frameworkProviderConfig, len(sdkSchema))
+	for name, s := range sdkSchema {
+		value, err := frameworkProviderConfigValue(s, values[name])
+		if err != nil {
+			return nil, fmt.Errorf("Could not read provider attribute %q: %w", name, err)
+		}
+		if value != nil && value != s.ZeroValue() {
+			config[name] = value
+		}
+	}
+
+	return config, nil
+}
+
+func (c frameworkProviderConfig) GetOk(key string) (interface{}, bool) {
+	v, ok := c[key]
+	return v, ok
+}
+
+func frameworkProviderConfigValue(s *schema.Schema, v tftypes.Value) (interface{}, error) {
+	if !v.IsKnown() {
+		return nil, nil
+	}
+
+	if v.IsNull() {
+		def, err := s.DefaultValue()
+		if err != nil || def == nil {
+			return nil, err
+		}
+		return weakProviderConfigValue(s.Type, def)
+	}
+
+	switch s.Type {
+	case schema.TypeString:
+		var value string
+		err := v.As(&value)
+		return value, err
+	case schema.TypeBool:
+		var value bool
+		err := v.As(&value)
+		return value, err
+	case schema.TypeInt, schema.TypeFloat:
+		var value big.Float
+		if err := v.As(&value); err != nil {
+			return nil, err
+		}
+		if s.Type == schema.TypeInt {
+			i, _ := value.Int64()
+			return int(i), nil
+		}
+		f, _ := value.Float64()
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", s.Type)
+}
+
+// weakProviderConfigValue converts a default value, such as the string read
+// from an environment variable, to the type of its attribute.
+func weakProviderConfigValue(t schema.ValueType, v interface{}) (interface{}, error) {
+	switch t {
+	case schema.TypeString:
+		return fmt.Sprint(v), nil
+	case schema.TypeBool:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+	case schema.TypeInt:
+		switch v := v.(type) {
+		case int:
+			return v, nil
+		case float64:
+			return int(v), nil
+		case string:
+			return strconv.Atoi(v)
+		}
+	case schema.TypeFloat:
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported default %#v for type %s", v, t)
+}
@@ -0,0 +1,296 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// httpLogSubsystem logs can be filtered with TF_LOG_PROVIDER_RPAAS_HTTP.
+	httpLogSubsystem = "http"
+
+	// bodies larger than this are truncated on logs
+	httpLogMaxBodySize = 64 * 1024
+
+	redacted = "(redacted)"
+)
+
+var (
+	redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+	// keys cut by the truncation of the body have no END line
+	privateKeyRegexp = regexp.MustCompile(`(?s)(-----BEGIN [A-Z ]*PRIVATE KEY-----)(?:.*?(-----END [A-Z ]*PRIVATE KEY-----)|.*$)`)
+)
+
+// httpLogLevelEnvs are the environment variables that set the level of the
+// "http" subsystem, from the most specific one.
+var httpLogLevelEnvs = []string{"TF_LOG_PROVIDER_RPAAS_HTTP", "TF_LOG_PROVIDER_RPAAS", "TF_LOG_PROVIDER", "TF_LOG"}
+
+type httpTraceKey struct{}
+
+// withHTTPLogging adds the "http" subsystem logger to ctx, for the requests
+// sent with it. It is set up once per provider operation.
+func withHTTPLogging(ctx context.Context) context.Context {
+	ctx = tflog.NewSubsystem(ctx, httpLogSubsystem,
+		tflog.WithLevelFromEnv("TF_LOG_PROVIDER_RPAAS", strings.ToUpper(httpLogSubsystem)),
+		tflog.WithRootFields(),
+	)
+
+	return context.WithValue(ctx, httpTraceKey{}, httpTraceEnabled())
+}
+
+// httpTraceEnabled tells whether headers and bodies may be logged. tflog
+// does not expose the level of a logger, so it is taken from the same
+// environment variables.
+func httpTraceEnabled() bool {
+	for _, env := range httpLogLevelEnvs {
+		if level := strings.ToUpper(os.Getenv(env)); level != "" {
+			return level == "TRACE" || level == "JSON"
+		}
+	}
+
+	return false
+}
+
+// logResourceOperations sets up the "http" subsystem logger on the CRUD
+// operations and the importer of every resource.
+func logResourceOperations(resources map[string]*schema.Resource) {
+	for _, r := range resources {
+		r.CreateContext = logResourceOperation(r.CreateContext)
+		r.ReadContext = logResourceOperation(r.ReadContext)
+		r.UpdateContext = logResourceOperation(r.UpdateContext)
+		r.DeleteContext = logResourceOperation(r.DeleteContext)
+
+		if r.Importer != nil && r.Importer.StateContext != nil {
+			importState := r.Importer.StateContext
+			r.Importer.StateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+				return importState(withHTTPLogging(ctx), d, meta)
+			}
+		}
+	}
+}
+
+func logResourceOperation[F ~func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics](fn F) F {
+	if fn == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		return fn(withHTTPLogging(ctx), d, meta)
+	}
+}
+
+var _ http.RoundTripper = (*loggingTransport)(nil)
+
+// loggingTransport logs every request sent to RPaaS and Tsuru APIs on the
+// "http" subsystem: method, URL, status and duration at DEBUG level, and
+// headers and bodies at TRACE level. Credentials are redacted. The subsystem
+// is taken from the request context, see withHTTPLogging; bodies are only
+// read when it is at TRACE level.
+type loggingTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+
+	fields := map[string]interface{}{
+		"method": r.Method,
+		"url":    r.URL.Redacted(),
+	}
+
+	trace, _ := ctx.Value(httpTraceKey{}).(bool)
+	if trace {
+		tflog.SubsystemTrace(ctx, httpLogSubsystem, "Sending HTTP request", mergeFields(fields, map[string]interface{}{
+			"headers": redactHeaders(r.Header),
+			"body":    requestBodyForLog(r),
+		}))
+	}
+
+	start := time.Now()
+	response, err := t.Base.RoundTrip(r)
+	fields["duration_ms"] = time.Since(start).Milliseconds()
+
+	if err != nil {
+		tflog.SubsystemDebug(ctx, httpLogSubsystem, "HTTP request failed", mergeFields(fields, map[string]interface{}{
+			"error": err.Error(),
+		}))
+		return response, err
+	}
+
+	fields["status"] = response.StatusCode
+	tflog.SubsystemDebug(ctx, httpLogSubsystem, "HTTP request", fields)
+
+	if trace {
+		tflog.SubsystemTrace(ctx, httpLogSubsystem, "Received HTTP response", mergeFields(fields, map[string]interface{}{
+			"headers": redactHeaders(response.Header),
+			"body":    responseBodyForLog(response),
+		}))
+	}
+
+	return response, nil
+}
+
+func redactHeaders(headers http.Header) map[string]string {
+	result := make(map[string]string, len(headers))
+	for name, values := range headers {
+		value := strings.Join(values, ", ")
+		for _, h := range redactedHeaders {
+			if strings.EqualFold(name, h) {
+				value = redacted
+			}
+		}
+		result[name] = value
+	}
+
+	return result
+}
+
+// redactBody hides private keys, such as the ones sent along certificates.
+// The fields of urlencoded forms holding a key are redacted as a whole, as
+// their encoded value does not match the PEM markers.
+func redactBody(body []byte, contentType string) string {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		return redactFormBody(string(body))
+	}
+
+	return redactPrivateKeys(string(body))
+}
+
+func redactPrivateKeys(s string) string {
+	return privateKeyRegexp.ReplaceAllStringFunc(s, func(key string) string {
+		m := privateKeyRegexp.FindStringSubmatch(key)
+		return strings.TrimSpace(m[1] + " " + redacted + " " + m[2])
+	})
+}
+
+func redactFormBody(body string) string {
+	fields := strings.Split(body, "&")
+	for i, field := range fields {
+		name, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+
+		// the value cut by the truncation of the body may end in the middle
+		// of an escape sequence
+		if j := strings.LastIndex(value, "%"); j >= 0 && len(value)-j < 3 {
+			value = value[:j]
+		}
+
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			decoded = value
+		}
+
+		if privateKeyRegexp.MatchString(decoded) {
+			fields[i] = name + "=" + redacted
+		}
+	}
+
+	return strings.Join(fields, "&")
+}
+
+// requestBodyForLog reads a copy of the request body, leaving the original
+// untouched. Bodies that cannot be read again are not logged.
+func requestBodyForLog(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+
+	if r.GetBody == nil || !isTextContent(r.Header.Get("Content-Type")) {
+		return "(not logged)"
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return "(not logged)"
+	}
+	defer body.Close()
+
+	return readBodyForLog(body, r.Header.Get("Content-Type"))
+}
+
+// responseBodyForLog reads up to httpLogMaxBodySize bytes of the response
+// body, and puts them back in front of the rest of the body.
+func responseBodyForLog(response *http.Response) string {
+	if response.Body == nil || response.Body == http.NoBody || !isTextContent(response.Header.Get("Content-Type")) {
+		return ""
+	}
+
+	head, err := io.ReadAll(io.LimitReader(response.Body, httpLogMaxBodySize+1))
+	response.Body = &multiReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(head), response.Body),
+		Closer: response.Body,
+	}
+	if err != nil {
+		return "(not logged)"
+	}
+
+	return truncateBodyForLog(head, response.Header.Get("Content-Type"))
+}
+
+func readBodyForLog(body io.Reader, contentType string) string {
+	content, err := io.ReadAll(io.LimitReader(body, httpLogMaxBodySize+1))
+	if err != nil {
+		return "(not logged)"
+	}
+
+	return truncateBodyForLog(content, contentType)
+}
+
+func truncateBodyForLog(body []byte, contentType string) string {
+	if len(body) > httpLogMaxBodySize {
+		return redactBody(body[:httpLogMaxBodySize], contentType) + "... (truncated)"
+	}
+
+	return redactBody(body, contentType)
+}
+
+func isTextContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		mediaType == "application/x-www-form-urlencoded" ||
+		mediaType == "multipart/form-data"
+}
+
+func mergeFields(fields ...map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, f := range fields {
+		for k, v := range f {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import "strings"
+
+const idSeparator = "::"
+
+var (
+	idEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	idUnescaper = strings.NewReplacer("%25", "%", "%3A", ":")
+)
+
+// buildID joins the ID components with "::". Each component has "%" and ":"
+// percent-escaped, so components containing "::" (e.g. regular expression
+// paths) never make the ID ambiguous.
+func buildID(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = idEscaper.Replace(p)
+	}
+
+	return strings.Join(escaped, idSeparator)
+}
+
+// splitID is the inverse of buildID. Only the escapes buildID produces are
+// unescaped, so "%" sequences in legacy or hand-written IDs (e.g. "%20" in a
+// route path) are kept as they are.
+func splitID(id string) []string {
+	parts := strings.Split(id, idSeparator)
+	for i, p := range parts {
+		parts[i] = idUnescaper.Replace(p)
+	}
+
+	return parts
+}
+
+// resourceIDFormat describes the ID of a resource type. It is shared by the
+// resource importer and the parse_id and build_id provider functions.
+type resourceIDFormat struct {
+	// Required and Optional are the attributes the ID is built from.
+	Required []string
+	Optional []string
+
+	// Parse parses the ID, in the current or a legacy format, into the
+	// attributes. Attributes legacy IDs lack are missing from the map.
+	Parse func(id string) (map[string]string, error)
+
+	// Build builds the ID from the attributes. Optional ones may be blank.
+	Build func(attributes map[string]string) string
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildID(t *testing.T) {
+	assert.Equal(t, "rpaasv2::my-rpaas", buildID("rpaasv2", "my-rpaas"))
+	assert.Equal(t, "rpaasv2::my-rpaas::/", buildID("rpaasv2", "my-rpaas", "/"))
+	assert.Equal(t, "rpaasv2::my-rpaas::~ ^/api%3A%3Av1", buildID("rpaasv2", "my-rpaas", "~ ^/api::v1"))
+	assert.Equal(t, "rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80", buildID("rpaasv2", "my-rpaas", "2001:db8::1", "80"))
+	assert.Equal(t, "rpaasv2::my-rpaas::100%25", buildID("rpaasv2", "my-rpaas", "100%"))
+	assert.Equal(t, "rpaasv2::my-rpaas::", buildID("rpaasv2", "my-rpaas", ""))
+}
+
+func TestSplitID(t *testing.T) {
+	assert.Equal(t, []string{"rpaasv2", "my-rpaas", "2001:db8::1", "80"}, splitID("rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80"))
+	assert.Equal(t, []string{"rpaasv2/my-rpaas"}, splitID("rpaasv2/my-rpaas"))
+	assert.Equal(t, []string{"rpaasv2", "my-rpaas", "100%"}, splitID("rpaasv2::my-rpaas::100%"))
+	assert.Equal(t, []string{"rpaasv2", "my-rpaas", "%3A"}, splitID("rpaasv2::my-rpaas::%253A"))
+
+	// other escapes are kept, as in legacy IDs
+	assert.Equal(t, []string{"rpaasv2", "my-rpaas", "/a%20b"}, splitID("rpaasv2::my-rpaas::/a%20b"))
+	assert.Equal(t, []string{"rpaasv2", "my-rpaas", "/caf%C3%A9%3a"}, splitID("rpaasv2::my-rpaas::/caf%C3%A9%3a"))
+}
+
+func FuzzBuildID(f *testing.F) {
+	f.Add("rpaasv2", "my-rpaas", "example.org", "/")
+	f.Add("rpaasv2", "my-rpaas", "", "~ ^/api::v1")
+	f.Add("rpaasv2", "my-rpaas", "2001:db8::1", "%3A")
+	f.Add("", "", "", "")
+
+	f.Fuzz(func(t *testing.T, a, b, c, d string) {
+		assert.Equal(t, []string{a, b, c, d}, splitID(buildID(a, b, c, d)))
+	})
+}
+
+func FuzzRpaasRouteID(f *testing.F) {
+	f.Add("rpaasv2", "my-rpaas", "", "/")
+	f.Add("rpaasv2", "my-rpaas", "example.org", "~ ^/api::v1")
+	f.Add("rpaasv2", "my-rpaas", "example.org", "/caf%C3%A9")
+
+	f.Fuzz(func(t *testing.T, service, instance, serverName, path string) {
+		s, i, sn, p, err := parseRpaasRouteID(buildRpaasRouteID(service, instance, serverName, path))
+		require.NoError(t, err)
+		assert.Equal(t, []string{service, instance, serverName, path}, []string{s, i, sn, p})
+	})
+}
+
+func FuzzRpaasBlockID(f *testing.F) {
+	f.Add("rpaasv2", "my-rpaas", "", "server")
+	f.Add("rpaasv2", "my-rpaas", "example.org", "lua-worker")
+
+	f.Fuzz(func(t *testing.T, service, instance, serverName, name string) {
+		s, i, sn, n, err := parseRpaasBlockID(buildRpaasBlockID(service, instance, serverName, name))
+		require.NoError(t, err)
+		assert.Equal(t, []string{service, instance, serverName, name}, []string{s, i, sn, n})
+	})
+}
+
+func FuzzACLID(f *testing.F) {
+	f.Add("rpaasv2", "my-rpaas", "example.org", 443)
+	f.Add("rpaasv2", "my-rpaas", "2001:db8::1", 80)
+
+	f.Fuzz(func(t *testing.T, service, instance, host string, port int) {
+		s, i, h, p, err := parseACLID(buildID(service, instance, host, strconv.Itoa(port)))
+		require.NoError(t, err)
+		assert.Equal(t, []string{service, instance, host}, []string{s, i, h})
+		assert.Equal(t, port, p)
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// importCandidate is an object found on RPaaS that could be imported, along
+// with the attributes used to match it against the import ID.
+type importCandidate struct {
+	ID         string
+	Attributes map[string]string
+}
+
+// resourceImporter builds the import step of a resource. The import ID may
+// either be the canonical resource ID or a list of attributes, such as
+// "service_name=rpaasv2,instance=my-rpaas,name=server". In both cases the
+// object must exist on RPaaS, and attributes omitted from the list match any
+// value, as long as only one object matches. The service_name and instance
+// default to the provider default_service_name and default_instance.
+type resourceImporter struct {
+	// Resource is the resource type name, only used on messages.
+	Resource string
+
+	// Keys are the attributes, besides service_name and instance, that can
+	// be used to select the object.
+	Keys []string
+
+	// ParseID parses the canonical ID into the attributes of the object.
+	// Attributes missing from the returned map match any value.
+	ParseID func(id string) (map[string]string, error)
+
+	// List lists every object of this resource type on the instance.
+	List func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error)
+
+	// Equal compares the values of the attributes given, when different
+	// values may be the same (e.g. "=/api" and "= /api" paths). Other
+	// attributes must be equal strings.
+	Equal map[string]func(a, b string) bool
+}
+
+var importKeyRegexp = regexp.MustCompile(`^[a-z_]+=`)
+
+func (i *resourceImporter) Importer() *schema.ResourceImporter {
+	return &schema.ResourceImporter{
+		StateContext: i.importState,
+	}
+}
+
+func (i *resourceImporter) importState(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	provider := meta.(*rpaasProvider)
+
+	attributes, err := i.attributes(d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName, instance := attributes["service_name"], attributes["instance"]
+	if provider.opts != nil {
+		if serviceName == "" {
+			serviceName = provider.opts.DefaultServiceName
+		}
+		if instance == "" {
+			instance = provider.opts.DefaultInstance
+		}
+	}
+
+	if serviceName == "" || instance == "" {
+		return nil, fmt.Errorf("Could not import %s %q: both service_name and instance are required", i.Resource, d.Id())
+	}
+
+	candidates, err := i.List(ctx, provider, serviceName, instance)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list %s objects on instance %s: %v", i.Resource, instance, err)
+	}
+
+	var matches []importCandidate
+	for _, c := range candidates {
+		if i.matches(c, attributes) {
+			matches = append(matches, c)
+		}
+	}
+
+	tflog.Info(ctx, "Import", map[string]interface{}{
+		"resource":   i.Resource,
+		"id":         d.Id(),
+		"service":    serviceName,
+		"instance":   instance,
+		"candidates": len(candidates),
+		"matches":    len(matches),
+	})
+
+	switch len(matches) {
+	case 1:
+		d.SetId(matches[0].ID)
+		return []*schema.ResourceData{d}, nil
+
+	case 0:
+		return nil, fmt.Errorf("Could not import %s %q: no matching object found on instance %s. %s", i.Resource, d.Id(), instance, describeImportCandidates(candidates))
+
+	default:
+		return nil, fmt.Errorf("Could not import %s %q: %d objects match on instance %s, set more attributes (%s) to choose one. %s", i.Resource, d.Id(), len(matches), instance, strings.Join(i.Keys, ", "), describeImportCandidates(matches))
+	}
+}
+
+func (i *resourceImporter) attributes(id string) (map[string]string, error) {
+	// canonical IDs start with the service name, so they never have a "key="
+	// prefix, while values may contain "::" (e.g. IPv6 hosts)
+	if !importKeyRegexp.MatchString(id) {
+		return i.ParseID(id)
+	}
+
+	attributes, err := parseImportAttributes(id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := append([]string{"service_name", "instance"}, i.Keys...)
+	for k := range attributes {
+		if !containsString(allowed, k) {
+			return nil, fmt.Errorf("Could not import %s %q: unexpected attribute %q. Allowed attributes: %s", i.Resource, id, k, strings.Join(allowed, ", "))
+		}
+	}
+
+	return attributes, nil
+}
+
+func (i *resourceImporter) matches(c importCandidate, attributes map[string]string) bool {
+	for k, v := range attributes {
+		if k == "service_name" || k == "instance" {
+			continue
+		}
+
+		if equal, ok := i.Equal[k]; ok {
+			if !equal(c.Attributes[k], v) {
+				return false
+			}
+			continue
+		}
+
+		if c.Attributes[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func describeImportCandidates(candidates []importCandidate) string {
+	if len(candidates) == 0 {
+		return "There are no objects of this type on the instance."
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, fmt.Sprintf("%q", c.ID))
+	}
+	sort.Strings(ids)
+
+	return fmt.Sprintf("Candidates: %s", strings.Join(ids, ", "))
+}
+
+// parseImportAttributes parses import IDs in the "key=value,key=value" form.
+// Values may contain "=" and ",", as a segment without "=" is appended to the
+// previous value.
+func parseImportAttributes(id string) (map[string]string, error) {
+	attributes := make(map[string]string)
+
+	var last string
+	for _, segment := range strings.Split(id, ",") {
+		if !importKeyRegexp.MatchString(segment) {
+			if last == "" {
+				return nil, fmt.Errorf("Could not parse import ID %q: expected \"key=value\" pairs separated by commas", id)
+			}
+			attributes[last] += "," + segment
+			continue
+		}
+
+		kv := strings.SplitN(segment, "=", 2)
+		if _, found := attributes[kv[0]]; found {
+			return nil, fmt.Errorf("Could not parse import ID %q: attribute %q is set more than once", id, kv[0])
+		}
+
+		last = kv[0]
+		attributes[last] = kv[1]
+	}
+
+	return attributes, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
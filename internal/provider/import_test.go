@@ -0,0 +1,184 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportAttributes(t *testing.T) {
+	tests := []struct {
+		id            string
+		expected      map[string]string
+		expectedError string
+	}{
+		{
+			id:       "service_name=rpaasv2,instance=my-rpaas,name=server",
+			expected: map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "name": "server"},
+		},
+		{
+			id:       "service_name=rpaasv2,instance=my-rpaas,path=~ ^/(a,b)=c$",
+			expected: map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "path": "~ ^/(a,b)=c$"},
+		},
+		{
+			id:       "service_name=rpaasv2,instance=my-rpaas,server_name=",
+			expected: map[string]string{"service_name": "rpaasv2", "instance": "my-rpaas", "server_name": ""},
+		},
+		{
+			id:            "service_name=rpaasv2,instance=my-rpaas,instance=other",
+			expectedError: `Could not parse import ID "service_name=rpaasv2,instance=my-rpaas,instance=other": attribute "instance" is set more than once`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			attributes, err := parseImportAttributes(tt.id)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, attributes)
+		})
+	}
+}
+
+func TestResourceImporter(t *testing.T) {
+	importer := &resourceImporter{
+		Resource: "rpaas_block",
+		Keys:     []string{"name", "server_name"},
+		ParseID: func(id string) (map[string]string, error) {
+			serviceName, instance, serverName, blockName, err := parseRpaasBlockID(id)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"service_name": serviceName, "instance": instance, "server_name": serverName, "name": blockName}, nil
+		},
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			return []importCandidate{
+				{ID: "rpaasv2::my-rpaas::http", Attributes: map[string]string{"name": "http"}},
+				{ID: "rpaasv2::my-rpaas::example.com::http", Attributes: map[string]string{"name": "http", "server_name": "example.com"}},
+				{ID: "rpaasv2::my-rpaas::server", Attributes: map[string]string{"name": "server"}},
+			}, nil
+		},
+	}
+
+	tests := []struct {
+		id            string
+		expectedID    string
+		expectedError string
+	}{
+		{
+			id:         "rpaasv2::my-rpaas::http",
+			expectedID: "rpaasv2::my-rpaas::http",
+		},
+		{
+			id:         "service_name=rpaasv2,instance=my-rpaas,name=server",
+			expectedID: "rpaasv2::my-rpaas::server",
+		},
+		{
+			id:         "service_name=rpaasv2,instance=my-rpaas,name=http,server_name=example.com",
+			expectedID: "rpaasv2::my-rpaas::example.com::http",
+		},
+		{
+			id:         "service_name=rpaasv2,instance=my-rpaas,name=http,server_name=",
+			expectedID: "rpaasv2::my-rpaas::http",
+		},
+		{
+			id:            "service_name=rpaasv2,instance=my-rpaas,name=http",
+			expectedError: `Could not import rpaas_block "service_name=rpaasv2,instance=my-rpaas,name=http": 2 objects match on instance my-rpaas, set more attributes (name, server_name) to choose one. Candidates: "rpaasv2::my-rpaas::example.com::http", "rpaasv2::my-rpaas::http"`,
+		},
+		{
+			id:            "rpaasv2::my-rpaas::location",
+			expectedError: `Could not import rpaas_block "rpaasv2::my-rpaas::location": no matching object found on instance my-rpaas. Candidates: "rpaasv2::my-rpaas::example.com::http", "rpaasv2::my-rpaas::http", "rpaasv2::my-rpaas::server"`,
+		},
+		{
+			id:            "service_name=rpaasv2,name=http",
+			expectedError: `Could not import rpaas_block "service_name=rpaasv2,name=http": both service_name and instance are required`,
+		},
+		{
+			id:            "service_name=rpaasv2,instance=my-rpaas,content=x",
+			expectedError: `Could not import rpaas_block "service_name=rpaasv2,instance=my-rpaas,content=x": unexpected attribute "content". Allowed attributes: service_name, instance, name, server_name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			d := resourceRpaasBlock().TestResourceData()
+			d.SetId(tt.id)
+
+			result, err := importer.importState(context.Background(), d, &rpaasProvider{})
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			assert.Equal(t, tt.expectedID, result[0].Id())
+		})
+	}
+}
+
+func TestResourceImporter_separatorInValues(t *testing.T) {
+	aclImporter := &resourceImporter{
+		Resource: "rpaas_acl",
+		Keys:     []string{"host", "port"},
+		ParseID:  rpaasACLID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			return []importCandidate{
+				{ID: buildID("rpaasv2", "my-rpaas", "2001:db8::1", "80"), Attributes: map[string]string{"host": "2001:db8::1", "port": "80"}},
+				{ID: buildID("rpaasv2", "my-rpaas", "2001:db8::2", "443"), Attributes: map[string]string{"host": "2001:db8::2", "port": "443"}},
+			}, nil
+		},
+	}
+
+	routeImporter := &resourceImporter{
+		Resource: "rpaas_route",
+		Keys:     []string{"path", "server_name"},
+		ParseID:  rpaasRouteID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			return []importCandidate{
+				{ID: buildRpaasRouteID("rpaasv2", "my-rpaas", "", "~ ^/api::v1"), Attributes: map[string]string{"path": "~ ^/api::v1", "server_name": ""}},
+				{ID: buildRpaasRouteID("rpaasv2", "my-rpaas", "", "=/exact"), Attributes: map[string]string{"path": "=/exact", "server_name": ""}},
+			}, nil
+		},
+		Equal: map[string]func(a, b string) bool{"path": sameRoutePath},
+	}
+
+	tests := []struct {
+		importer   *resourceImporter
+		resource   *schema.Resource
+		id         string
+		expectedID string
+	}{
+		{importer: aclImporter, resource: resourceRpaasACL(), id: "service_name=rpaasv2,instance=my-rpaas,host=2001:db8::1", expectedID: "rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80"},
+		{importer: aclImporter, resource: resourceRpaasACL(), id: "service_name=rpaasv2,instance=my-rpaas,host=2001:db8::2,port=443", expectedID: "rpaasv2::my-rpaas::2001%3Adb8%3A%3A2::443"},
+		{importer: aclImporter, resource: resourceRpaasACL(), id: "rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80", expectedID: "rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80"},
+		{importer: routeImporter, resource: resourceRpaasRoute(), id: "service_name=rpaasv2,instance=my-rpaas,path=~ ^/api::v1", expectedID: "rpaasv2::my-rpaas::~ ^/api%3A%3Av1"},
+		{importer: routeImporter, resource: resourceRpaasRoute(), id: "service_name=rpaasv2,instance=my-rpaas,path== /exact", expectedID: "rpaasv2::my-rpaas::=/exact"},
+		{importer: routeImporter, resource: resourceRpaasRoute(), id: "rpaasv2::my-rpaas::=   /exact", expectedID: "rpaasv2::my-rpaas::=/exact"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			for _, key := range tt.importer.Keys {
+				assert.Contains(t, tt.resource.Schema, key)
+			}
+
+			d := tt.resource.TestResourceData()
+			d.SetId(tt.id)
+
+			result, err := tt.importer.importState(context.Background(), d, &rpaasProvider{})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			assert.Equal(t, tt.expectedID, result[0].Id())
+		})
+	}
+}
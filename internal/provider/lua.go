@@ -0,0 +1,66 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var luaBlocks = []string{"lua-server", "lua-worker"}
+
+func isLuaBlock(name string) bool {
+	for _, b := range luaBlocks {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isLuaFile(name string) bool {
+	return strings.HasSuffix(name, ".lua")
+}
+
+// validateLuaBlock validates the Lua syntax of the content of a Lua block.
+// The operator renders blocks as Go templates before nginx gets them, so
+// content with template actions, such as "{{ .Name }}", is not Lua yet and
+// is left for the operator to render.
+func validateLuaBlock(attribute, content string) error {
+	if strings.Contains(content, "{{") {
+		return nil
+	}
+
+	return validateLuaSyntax(attribute, content)
+}
+
+// validateLuaSyntax parses the Lua code without running it, so syntax errors
+// are caught at plan time instead of on nginx reload. The returned error
+// mentions the attribute holding the code and the location of the failure.
+func validateLuaSyntax(attribute, code string) error {
+	_, err := parse.Parse(strings.NewReader(code), attribute)
+	if err == nil {
+		return nil
+	}
+
+	var perr *parse.Error
+	if !errors.As(err, &perr) {
+		return fmt.Errorf("Invalid Lua code in %q: %v", attribute, err)
+	}
+
+	message := strings.TrimSpace(perr.Message)
+	if perr.Token != "" {
+		message = fmt.Sprintf("%s near '%s'", message, perr.Token)
+	}
+
+	if perr.Pos.Line == parse.EOF {
+		return fmt.Errorf("Invalid Lua code in %q (at end of input): %s", attribute, message)
+	}
+
+	return fmt.Errorf("Invalid Lua code in %q (line %d, column %d): %s", attribute, perr.Pos.Line, perr.Pos.Column, message)
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLuaSyntax(t *testing.T) {
+	tests := []struct {
+		code          string
+		expectedError string
+	}{
+		{
+			code: "local t = {a = 1}\nngx.say(t.a)\nreturn t",
+		},
+		{
+			code: "",
+		},
+		{
+			code:          "local x = 1\nfunction foo(\n",
+			expectedError: `Invalid Lua code in "content" (at end of input): syntax error near '('`,
+		},
+		{
+			code:          "local x =\nfunction foo() end",
+			expectedError: `Invalid Lua code in "content" (line 2, column 12): syntax error near 'foo'`,
+		},
+		{
+			code:          "ngx.say(\"unterminated)",
+			expectedError: `Invalid Lua code in "content" (at end of input): unterminated string near 'unterminated)'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			err := validateLuaSyntax("content", tt.code)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestValidateLuaBlock(t *testing.T) {
+	assert.NoError(t, validateLuaBlock("content", "local name = \"{{ .Name }}\"\n{{ if .Config }}ngx.log(ngx.ERR, name){{ end }}"))
+	assert.NoError(t, validateLuaBlock("content", "local upstreams = {{ range .Upstreams }}{{ . }},{{ end }}"))
+	assert.EqualError(t, validateLuaBlock("template", "local x =\nfunction foo() end"), `Invalid Lua code in "template" (line 2, column 12): syntax error near 'foo'`)
+}
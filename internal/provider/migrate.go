@@ -0,0 +1,161 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Schema version 0 of every resource stored IDs as components joined by "::"
+// without any escaping. Version 1 builds them with buildID, so components
+// containing ":" or "%" are percent-escaped.
+
+func resourceRpaasAutoscaleV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":                                cty.String,
+		"instance":                          cty.String,
+		"service_name":                      cty.String,
+		"min_replicas":                      cty.Number,
+		"max_replicas":                      cty.Number,
+		"target_cpu_utilization_percentage": cty.Number,
+		"target_requests_per_second":        cty.Number,
+		"scheduled_window": cty.List(cty.Object(map[string]cty.Type{
+			"min_replicas": cty.Number,
+			"start":        cty.String,
+			"end":          cty.String,
+		})),
+	})
+}
+
+func resourceRpaasBlockV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":           cty.String,
+		"instance":     cty.String,
+		"service_name": cty.String,
+		"server_name":  cty.String,
+		"extend":       cty.Bool,
+		"name":         cty.String,
+		"content":      cty.String,
+	})
+}
+
+func resourceRpaasRouteV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":           cty.String,
+		"instance":     cty.String,
+		"service_name": cty.String,
+		"server_name":  cty.String,
+		"path":         cty.String,
+		"destination":  cty.String,
+		"content":      cty.String,
+		"https_only":   cty.Bool,
+	})
+}
+
+func resourceRpaasCertificateV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":           cty.String,
+		"instance":     cty.String,
+		"service_name": cty.String,
+		"name":         cty.String,
+		"certificate":  cty.String,
+		"key":          cty.String,
+	})
+}
+
+func resourceRpaasCertManagerV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":               cty.String,
+		"instance":         cty.String,
+		"service_name":     cty.String,
+		"issuer":           cty.String,
+		"certificate_name": cty.String,
+		"dns_names":        cty.List(cty.String),
+	})
+}
+
+func resourceRpaasACLV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":           cty.String,
+		"instance":     cty.String,
+		"service_name": cty.String,
+		"host":         cty.String,
+		"port":         cty.Number,
+	})
+}
+
+func resourceRpaasFileV0Type() cty.Type {
+	return cty.Object(map[string]cty.Type{
+		"id":             cty.String,
+		"instance":       cty.String,
+		"service_name":   cty.String,
+		"name":           cty.String,
+		"content":        cty.String,
+		"content_base64": cty.String,
+	})
+}
+
+func resourceStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	return upgradeStateIDV0(rawState, func(id string) []string {
+		return strings.Split(id, "::")
+	}), nil
+}
+
+func resourceRpaasRouteStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	return upgradeStateIDV0(rawState, func(id string) []string {
+		parts := strings.SplitN(id, "::", 3)
+		if len(parts) != 3 || isRoutePathPrefix(parts[2]) {
+			return parts
+		}
+
+		// paths start with either "/" or a location modifier and may contain
+		// "::" themselves, while server names do not
+		return append(parts[:2], strings.SplitN(parts[2], "::", 2)...)
+	}), nil
+}
+
+func resourceRpaasACLStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	return upgradeStateIDV0(rawState, func(id string) []string {
+		parts := strings.Split(id, "::")
+		if len(parts) <= 4 {
+			return parts
+		}
+
+		// IPv6 hosts contain "::", the port is always the last component
+		host := strings.Join(parts[2:len(parts)-1], "::")
+		return []string{parts[0], parts[1], host, parts[len(parts)-1]}
+	}), nil
+}
+
+// upgradeStateIDV0 rebuilds the ID using the escaped format. IDs using the
+// even older formats (without "::") are kept as they are, since the parsers
+// still understand them.
+func upgradeStateIDV0(rawState map[string]interface{}, split func(id string) []string) map[string]interface{} {
+	if rawState == nil {
+		return rawState
+	}
+
+	id, ok := rawState["id"].(string)
+	if !ok || !strings.Contains(id, "::") {
+		return rawState
+	}
+
+	rawState["id"] = buildID(split(id)...)
+	return rawState
+}
+
+func stateUpgradersV0(t cty.Type, upgrade schema.StateUpgradeFunc) []schema.StateUpgrader {
+	return []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    t,
+			Upgrade: upgrade,
+		},
+	}
+}
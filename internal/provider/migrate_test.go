@@ -0,0 +1,48 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceStateUpgradeV0(t *testing.T) {
+	tests := []struct {
+		name     string
+		upgrade  func(context.Context, map[string]interface{}, interface{}) (map[string]interface{}, error)
+		id       string
+		expected string
+	}{
+		{name: "plain ID", upgrade: resourceStateUpgradeV0, id: "rpaasv2::my-rpaas::server", expected: "rpaasv2::my-rpaas::server"},
+		{name: "ID with percent sign", upgrade: resourceStateUpgradeV0, id: "rpaasv2::my-rpaas::100%.txt", expected: "rpaasv2::my-rpaas::100%25.txt"},
+		{name: "legacy slash ID", upgrade: resourceStateUpgradeV0, id: "rpaasv2/my-rpaas", expected: "rpaasv2/my-rpaas"},
+		{name: "legacy space ID", upgrade: resourceStateUpgradeV0, id: "rpaasv2 my-rpaas my-cert", expected: "rpaasv2 my-rpaas my-cert"},
+		{name: "route", upgrade: resourceRpaasRouteStateUpgradeV0, id: "rpaasv2::my-rpaas::/", expected: "rpaasv2::my-rpaas::/"},
+		{name: "route with server name", upgrade: resourceRpaasRouteStateUpgradeV0, id: "rpaasv2::my-rpaas::example.org::/api", expected: "rpaasv2::my-rpaas::example.org::/api"},
+		{name: "route path with separator", upgrade: resourceRpaasRouteStateUpgradeV0, id: "rpaasv2::my-rpaas::~ ^/api::v1", expected: "rpaasv2::my-rpaas::~ ^/api%3A%3Av1"},
+		{name: "route with server name and path with separator", upgrade: resourceRpaasRouteStateUpgradeV0, id: "rpaasv2::my-rpaas::example.org::/api::v1", expected: "rpaasv2::my-rpaas::example.org::/api%3A%3Av1"},
+		{name: "acl", upgrade: resourceRpaasACLStateUpgradeV0, id: "rpaasv2::my-rpaas::example.org::443", expected: "rpaasv2::my-rpaas::example.org::443"},
+		{name: "acl with IPv6 host", upgrade: resourceRpaasACLStateUpgradeV0, id: "rpaasv2::my-rpaas::2001:db8::1::80", expected: "rpaasv2::my-rpaas::2001%3Adb8%3A%3A1::80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := tt.upgrade(context.Background(), map[string]interface{}{"id": tt.id, "instance": "my-rpaas"}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, state["id"])
+			assert.Equal(t, "my-rpaas", state["instance"])
+		})
+	}
+}
+
+func TestResourceStateUpgradeV0_nilState(t *testing.T) {
+	state, err := resourceStateUpgradeV0(context.Background(), nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
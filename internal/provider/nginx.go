@@ -0,0 +1,127 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// suppressEquivalentNginxConfig hides differences on the content attribute
+// that are not meaningful to nginx, such as indentation or trailing newlines
+// added by the API. Comments are only ignored when the resource sets
+// ignore_comments.
+func suppressEquivalentNginxConfig(k, old, new string, d *schema.ResourceData) bool {
+	return equivalentNginxConfig(old, new, d.Get("ignore_comments").(bool))
+}
+
+// suppressEquivalentBlockContent behaves like suppressEquivalentNginxConfig,
+// except for Lua blocks where only trailing whitespace is ignored.
+func suppressEquivalentBlockContent(k, old, new string, d *schema.ResourceData) bool {
+	if isLuaBlock(d.Get("name").(string)) {
+		return normalizeLuaCode(old) == normalizeLuaCode(new)
+	}
+
+	return suppressEquivalentNginxConfig(k, old, new, d)
+}
+
+func equivalentNginxConfig(a, b string, ignoreComments bool) bool {
+	return normalizeNginxConfig(a, ignoreComments) == normalizeNginxConfig(b, ignoreComments)
+}
+
+// normalizeNginxConfig splits the nginx configuration into its tokens
+// (directives, arguments, quoted strings, braces, semicolons and comments) and
+// joins them back with a single space, so two snippets produce the same
+// output whenever nginx would parse them the same way.
+func normalizeNginxConfig(content string, ignoreComments bool) string {
+	tokens := nginxTokens(content)
+
+	normalized := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if ignoreComments && strings.HasPrefix(token, "#") {
+			continue
+		}
+		normalized = append(normalized, token)
+	}
+
+	return strings.Join(normalized, " ")
+}
+
+func nginxTokens(content string) []string {
+	var tokens []string
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '{' || r == '}' || r == ';':
+			tokens = append(tokens, string(r))
+			i++
+
+		case r == '#':
+			start := i
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			tokens = append(tokens, strings.TrimRightFunc(string(runes[start:i]), unicode.IsSpace))
+
+		case r == '"' || r == '\'':
+			start := i
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			i++
+			if i > len(runes) {
+				i = len(runes)
+			}
+			tokens = append(tokens, string(runes[start:i]))
+
+		default:
+			start := i
+			for i < len(runes) {
+				c := runes[i]
+				if c == '$' && i+1 < len(runes) && runes[i+1] == '{' {
+					for i < len(runes) && runes[i] != '}' {
+						i++
+					}
+					i++
+					continue
+				}
+				if unicode.IsSpace(c) || c == '{' || c == '}' || c == ';' {
+					break
+				}
+				if c == '\\' {
+					i++
+				}
+				i++
+			}
+			if i > len(runes) {
+				i = len(runes)
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+
+	return tokens
+}
+
+// normalizeLuaCode only ignores trailing whitespace, as leading whitespace
+// may be meaningful inside Lua long strings.
+func normalizeLuaCode(code string) string {
+	lines := strings.Split(code, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRightFunc(lines[i], unicode.IsSpace)
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
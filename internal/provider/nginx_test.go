@@ -0,0 +1,91 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeNginxConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		ignoreComments bool
+		expected       string
+	}{
+		{
+			name:     "empty",
+			content:  "",
+			expected: "",
+		},
+		{
+			name:     "trailing newline",
+			content:  "# nginx config\n",
+			expected: "# nginx config",
+		},
+		{
+			name:     "indentation and blank lines",
+			content:  "\n  location / {\n\n\t\tproxy_pass   http://app;\n  }\n\n",
+			expected: "location / { proxy_pass http://app ; }",
+		},
+		{
+			name:     "quoted strings keep their whitespace",
+			content:  "more_set_headers   'X-Frame-Options:  deny';",
+			expected: "more_set_headers 'X-Frame-Options:  deny' ;",
+		},
+		{
+			name:     "escaped quotes",
+			content:  `return 200 "say \"hi\"  there";`,
+			expected: `return 200 "say \"hi\"  there" ;`,
+		},
+		{
+			name:     "variables with braces",
+			content:  "proxy_set_header X-Forwarded-Host ${host};\nreturn 301 https://${http_host}${request_uri};",
+			expected: "proxy_set_header X-Forwarded-Host ${host} ; return 301 https://${http_host}${request_uri} ;",
+		},
+		{
+			name:     "hash inside a word is not a comment",
+			content:  "return 302 /page#anchor;",
+			expected: "return 302 /page#anchor ;",
+		},
+		{
+			name:     "comments are kept by default",
+			content:  "# first\nlisten 80; # trailing   \n",
+			expected: "# first listen 80 ; # trailing",
+		},
+		{
+			name:           "comments can be ignored",
+			content:        "# first\nlisten 80; # trailing\n",
+			ignoreComments: true,
+			expected:       "listen 80 ;",
+		},
+		{
+			name:     "unterminated quote",
+			content:  "return 200 'oops",
+			expected: "return 200 'oops",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, normalizeNginxConfig(tt.content, tt.ignoreComments))
+		})
+	}
+}
+
+func TestEquivalentNginxConfig(t *testing.T) {
+	assert.True(t, equivalentNginxConfig("location / {\n  return 200;\n}\n", "location / { return 200; }", false))
+	assert.True(t, equivalentNginxConfig("listen 80; # http", "listen 80;", true))
+	assert.False(t, equivalentNginxConfig("listen 80; # http", "listen 80;", false))
+	assert.False(t, equivalentNginxConfig("return 200 'a  b';", "return 200 'a b';", false))
+	assert.False(t, equivalentNginxConfig("listen 80;", "listen 8080;", true))
+}
+
+func TestNormalizeLuaCode(t *testing.T) {
+	assert.Equal(t, "local x = 1\n  return x", normalizeLuaCode("local x = 1   \n  return x\n\n"))
+	assert.NotEqual(t, normalizeLuaCode("local s = [[\n  a]]"), normalizeLuaCode("local s = [[\na]]"))
+}
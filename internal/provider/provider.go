@@ -10,22 +10,32 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	tsuruclient "github.com/tsuru/go-tsuruclient/pkg/client"
-	"github.com/tsuru/go-tsuruclient/pkg/config"
 	rpaasclient "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
 	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/autogenerated"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var Version string = "undefined"
 
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	return newProvider(&configuredProvider{})
+}
+
+// newProvider returns the SDKv2 provider, handing its configuration over to
+// the plugin framework one through configured.
+func newProvider(configured *configuredProvider) *schema.Provider {
+	provider := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"rpaas_url": {
 				Type:        schema.TypeString,
@@ -47,7 +57,7 @@ func Provider() *schema.Provider {
 			},
 			"tsuru_target": {
 				Type:        schema.TypeString,
-				Description: "URL address for Tsuru API.",
+				Description: "URL address (or label, as in `tsuru target list`) for Tsuru API. Defaults to the current target of the tsuru client.",
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TSURU_TARGET", nil),
 			},
@@ -57,6 +67,24 @@ func Provider() *schema.Provider {
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("TSURU_TOKEN", nil),
 			},
+			"tsuru_token_command": {
+				Type:        schema.TypeString,
+				Description: "Shell command printing the authentication token for Tsuru API. It runs again whenever the token expires or is rejected. Ignored when `tsuru_token` is set.",
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TSURU_TOKEN_COMMAND", nil),
+			},
+			"tsuru_token_file": {
+				Type:        schema.TypeString,
+				Description: "Path to a file with the authentication token for Tsuru API. It is read again whenever the token expires or is rejected. Ignored when `tsuru_token` or `tsuru_token_command` are set.",
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TSURU_TOKEN_FILE", nil),
+			},
+			"tsuru_config_dir": {
+				Type:        schema.TypeString,
+				Description: "Directory with the tsuru client configuration, used to find the target and token when they are not set. Defaults to `~/.tsuru`.",
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("TSURU_CONFIG_DIR", nil),
+			},
 			"http_timeout_in_seconds": {
 				Type:        schema.TypeInt,
 				Description: "Timeout in seconds a HTTP request can take. Zero means no limit.",
@@ -69,6 +97,62 @@ func Provider() *schema.Provider {
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("SKIP_CERT_VERIFICATION", nil),
 			},
+			"ca_cert_pem": {
+				Type:          schema.TypeString,
+				Description:   "PEM encoded CA certificates trusted on TLS connections, besides the system ones.",
+				Optional:      true,
+				ConflictsWith: []string{"ca_cert_file"},
+			},
+			"ca_cert_file": {
+				Type:          schema.TypeString,
+				Description:   "Path to a file with PEM encoded CA certificates trusted on TLS connections, besides the system ones.",
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("RPAAS_CA_CERT_FILE", nil),
+				ConflictsWith: []string{"ca_cert_pem"},
+			},
+			"client_cert_pem": {
+				Type:         schema.TypeString,
+				Description:  "PEM encoded client certificate presented on TLS connections (mutual TLS).",
+				Optional:     true,
+				RequiredWith: []string{"client_key_pem"},
+			},
+			"client_key_pem": {
+				Type:         schema.TypeString,
+				Description:  "PEM encoded private key of the client certificate.",
+				Optional:     true,
+				Sensitive:    true,
+				RequiredWith: []string{"client_cert_pem"},
+			},
+			"max_requests_per_second": {
+				Type:         schema.TypeFloat,
+				Description:  "Maximum rate of requests sent to RPaaS and Tsuru APIs, shared by every resource. Zero means no limit.",
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("RPAAS_MAX_REQUESTS_PER_SECOND", 0),
+				ValidateFunc: validation.FloatAtLeast(0),
+			},
+			"max_concurrent_requests": {
+				Type:         schema.TypeInt,
+				Description:  "Maximum number of requests to RPaaS and Tsuru APIs in flight at once, shared by every resource. Zero means no limit.",
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("RPAAS_MAX_CONCURRENT_REQUESTS", 0),
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"wait_for_rollout": {
+				Type:        schema.TypeBool,
+				Description: "Whether resources changing the nginx configuration (blocks, routes, files and certificates) wait until every pod of the instance runs it and is ready. Pods crashlooping on the new configuration, or a rollout not done within the resource timeout, fail the apply. Pods are told apart by the pod template hash in their names. When no new pod shows up within a minute, the change is taken as rendering the same configuration, and only every pod has to be ready. Instances without pods are not waited for. Resources may override it.",
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("RPAAS_WAIT_FOR_ROLLOUT", false),
+			},
+			"default_service_name": {
+				Type:        schema.TypeString,
+				Description: "Service name used by resources that omit `service_name`.",
+				Optional:    true,
+			},
+			"default_instance": {
+				Type:        schema.TypeString,
+				Description: "Instance name used by resources that omit `instance`.",
+				Optional:    true,
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"rpaas_autoscale":    resourceRpaasAutoscale(),
@@ -78,56 +162,110 @@ func Provider() *schema.Provider {
 			"rpaas_cert_manager": resourceRpaasCertManager(),
 			"rpaas_acl":          resourceRpaasACL(),
 			"rpaas_file":         resourceRpaasFile(),
+			"rpaas_metadata":     resourceRpaasMetadata(),
 		},
 		ConfigureContextFunc: func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
-			return providerConfigure(ctx, d)
+			provider, err := configured.configure(ctx, d)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			return provider, nil
 		},
 	}
+
+	traceResourceOperations(provider.ResourcesMap)
+	logResourceOperations(provider.ResourcesMap)
+
+	return provider
 }
 
 type rpaasProvider struct {
-	RpaasClient rpaasclient.Client
-	opts        *ProviderConfigOptions
+	opts    *ProviderConfigOptions
+	cache   *instanceCache
+	clients clientPool
 }
 
-func (rp *rpaasProvider) Client(service, instance string) *autogenerated.APIClient {
-	opts := *rp.opts
-	opts.TsuruService = service
-	opts.TsuruInstance = instance
-
-	return getAutogeneratedClient(&opts)
+func (rp *rpaasProvider) Client(service, instance string) *rpaasAPI {
+	return rp.clients.Client(rp.opts, service, instance)
 }
 
-func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
-	providerOpts, err := getProviderConfigOpts(d)
+func providerConfigure(ctx context.Context, d providerConfig) (interface{}, diag.Diagnostics) {
+	provider, err := newRPaaSProvider(ctx, d)
 	if err != nil {
 		return nil, diag.FromErr(err)
 	}
+	return provider, nil
+}
 
-	legacyClient, err := getLegacyClient(providerOpts)
+func newRPaaSProvider(ctx context.Context, d providerConfig) (*rpaasProvider, error) {
+	providerOpts, err := getProviderConfigOpts(ctx, d)
 	if err != nil {
-		return nil, diag.FromErr(err)
+		return nil, err
+	}
+
+	providerOpts.tracerProvider, err = newTracerProvider(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	return &rpaasProvider{
-		RpaasClient: legacyClient,
-		opts:        providerOpts,
+		opts:  providerOpts,
+		cache: newInstanceCache(),
 	}, nil
 }
 
+// providerConfig is the provider configuration, as read by either the SDKv2
+// (*schema.ResourceData) or the plugin framework (frameworkProviderConfig).
+type providerConfig interface {
+	GetOk(key string) (interface{}, bool)
+}
+
+// configuredProvider hands the RPaaS provider over between both halves of the
+// muxed server: whichever is configured first builds it from its own copy of
+// the configuration, and the other one reuses it, whatever the mux order.
+type configuredProvider struct {
+	once     sync.Once
+	provider *rpaasProvider
+	err      error
+}
+
+func (c *configuredProvider) configure(ctx context.Context, config providerConfig) (*rpaasProvider, error) {
+	c.once.Do(func() {
+		c.provider, c.err = newRPaaSProvider(ctx, config)
+	})
+	return c.provider, c.err
+}
+
 type ProviderConfigOptions struct {
 	URL                string
 	Username           string
 	Password           string
 	TsuruTarget        string
 	TsuruToken         string
+	TsuruTokenCommand  string
+	TsuruTokenFile     string
+	TsuruConfigDir     string
 	TsuruService       string
 	TsuruInstance      string
 	Timeout            time.Duration
 	InsecureSkipVerify bool
+	CACertPEM          string
+	ClientCertPEM      string
+	ClientKeyPEM       string
+	DefaultServiceName string
+	DefaultInstance    string
+	WaitForRollout     bool
+
+	MaxRequestsPerSecond  float64
+	MaxConcurrentRequests int
+
+	requestLimiter *requestLimiter
+	tlsConfig      *tls.Config
+	tokenSource    *tsuruTokenSource
+	tracerProvider trace.TracerProvider
 }
 
-func getProviderConfigOpts(d *schema.ResourceData) (*ProviderConfigOptions, error) {
+func getProviderConfigOpts(ctx context.Context, d providerConfig) (*ProviderConfigOptions, error) {
 	var opts ProviderConfigOptions
 
 	if v, ok := d.GetOk("rpaas_url"); ok {
@@ -150,6 +288,18 @@ func getProviderConfigOpts(d *schema.ResourceData) (*ProviderConfigOptions, erro
 		opts.TsuruToken = v.(string)
 	}
 
+	if v, ok := d.GetOk("tsuru_token_command"); ok {
+		opts.TsuruTokenCommand = v.(string)
+	}
+
+	if v, ok := d.GetOk("tsuru_token_file"); ok {
+		opts.TsuruTokenFile = v.(string)
+	}
+
+	if v, ok := d.GetOk("tsuru_config_dir"); ok {
+		opts.TsuruConfigDir = v.(string)
+	}
+
 	if v, ok := d.GetOk("http_timeout_in_seconds"); ok {
 		opts.Timeout = time.Duration(v.(int)) * time.Second
 	}
@@ -158,100 +308,189 @@ func getProviderConfigOpts(d *schema.ResourceData) (*ProviderConfigOptions, erro
 		opts.InsecureSkipVerify = v.(bool)
 	}
 
-	if opts.TsuruTarget != "" {
-		target, err := config.ReadTarget()
+	if v, ok := d.GetOk("ca_cert_pem"); ok {
+		opts.CACertPEM = v.(string)
+	}
+
+	if v, ok := d.GetOk("ca_cert_file"); ok {
+		content, err := os.ReadFile(v.(string))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Could not read ca_cert_file: %w", err)
 		}
+		opts.CACertPEM = string(content)
+	}
 
-		opts.TsuruTarget = target
+	if v, ok := d.GetOk("client_cert_pem"); ok {
+		opts.ClientCertPEM = v.(string)
 	}
 
-	if opts.TsuruTarget != "" && opts.TsuruToken == "" {
-		token, err := readToken()
-		if err != nil {
-			return nil, err
-		}
+	if v, ok := d.GetOk("client_key_pem"); ok {
+		opts.ClientKeyPEM = v.(string)
+	}
+
+	if v, ok := d.GetOk("default_service_name"); ok {
+		opts.DefaultServiceName = v.(string)
+	}
 
-		opts.TsuruToken = token
+	if v, ok := d.GetOk("default_instance"); ok {
+		opts.DefaultInstance = v.(string)
+	}
+
+	if v, ok := d.GetOk("wait_for_rollout"); ok {
+		opts.WaitForRollout = v.(bool)
+	}
+
+	if v, ok := d.GetOk("max_requests_per_second"); ok {
+		opts.MaxRequestsPerSecond = v.(float64)
+	}
+
+	if v, ok := d.GetOk("max_concurrent_requests"); ok {
+		opts.MaxConcurrentRequests = v.(int)
+	}
+
+	opts.requestLimiter = newRequestLimiter(opts.MaxRequestsPerSecond, opts.MaxConcurrentRequests)
+
+	tlsConfig, err := buildTLSConfig(&opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.tlsConfig = tlsConfig
+
+	if err := resolveTsuruTargetAndToken(ctx, &opts); err != nil {
+		return nil, err
 	}
 
 	return &opts, nil
 }
 
-func getLegacyClient(opts *ProviderConfigOptions) (rpaasclient.Client, error) {
-	legacyClientOpts := rpaasclient.ClientOptions{
-		InsecureSkipVerify: opts.InsecureSkipVerify,
-		Timeout:            opts.Timeout,
+// resolveTsuruTargetAndToken fills the Tsuru target and token missing from
+// the provider configuration (either the attributes or their environment
+// variables) using the tsuru client configuration files. The target is only
+// needed when the RPaaS API is not reached directly through rpaas_url.
+func resolveTsuruTargetAndToken(ctx context.Context, opts *ProviderConfigOptions) error {
+	if opts.URL != "" && opts.TsuruTarget == "" {
+		return nil
 	}
 
-	if opts.URL != "" {
-		return rpaasclient.NewClientWithOptions(opts.URL, opts.Username, opts.Password, legacyClientOpts)
+	tsuruConfig, err := newTsuruConfig(opts.TsuruConfigDir)
+	if err != nil {
+		return err
 	}
 
-	service := "unset"
-	if opts.TsuruService != "" {
-		service = opts.TsuruService
+	if opts.TsuruTarget == "" {
+		opts.TsuruTarget, err = tsuruConfig.CurrentTarget()
+	} else {
+		opts.TsuruTarget, err = tsuruConfig.ResolveTarget(opts.TsuruTarget)
+	}
+	if err != nil {
+		return err
 	}
 
-	if opts.TsuruToken == "" {
-		token, err := readToken()
-		if err != nil {
-			return nil, err
-		}
+	if opts.TsuruTarget == "" {
+		return fmt.Errorf("Tsuru target not found: either set rpaas_url or tsuru_target, or define a target with \"tsuru target set\" (looked into %s)", tsuruConfig.dir)
+	}
 
-		opts.TsuruToken = token
+	if opts.TsuruToken != "" {
+		return nil
 	}
 
-	return rpaasclient.NewClientThroughTsuruWithOptions(opts.TsuruTarget, opts.TsuruToken, service, legacyClientOpts)
-}
+	switch {
+	case opts.TsuruTokenCommand != "":
+		opts.tokenSource = newTsuruTokenSource(tokenCommandFetcher(opts.TsuruTokenCommand))
+
+	case opts.TsuruTokenFile != "":
+		opts.tokenSource = newTsuruTokenSource(tokenFileFetcher(opts.TsuruTokenFile))
 
-func readToken() (string, error) {
-	_, tokenProvider, err := tsuruclient.RoundTripperAndTokenProvider()
+	default:
+		target := opts.TsuruTarget
+		opts.tokenSource = newTsuruTokenSource(func(ctx context.Context) (string, error) {
+			return tsuruConfig.Token(ctx, target)
+		})
+	}
+
+	opts.TsuruToken, err = opts.tokenSource.Token(ctx)
 	if err != nil {
-		return "", err
+		return err
 	}
-	return tokenProvider.Token()
+
+	return nil
 }
 
 func getAutogeneratedClient(opts *ProviderConfigOptions) *autogenerated.APIClient {
-	serverURL := opts.URL
-	if serverURL == "" {
-		serverURL = opts.TsuruTarget
-	}
+	return newAutogeneratedClient(opts, newSharedTransport(opts), opts.TsuruService, opts.TsuruInstance)
+}
 
-	cfg := &autogenerated.Configuration{
-		Servers: autogenerated.ServerConfigurations{{URL: serverURL}},
-		HTTPClient: &http.Client{
-			Transport: baseHTTPTransport(opts.InsecureSkipVerify),
-			Timeout:   opts.Timeout,
+// newSharedTransport builds the part of the transport that does not depend
+// on the service and instance, so it can be shared by autogenerated clients.
+func newSharedTransport(opts *ProviderConfigOptions) http.RoundTripper {
+	var transport http.RoundTripper = &rateLimitTransport{
+		Limiter: opts.requestLimiter,
+		Base: &tracingTransport{
+			TracerProvider: tracerProvider(opts),
+			Base:           &loggingTransport{Base: baseHTTPTransport(opts)},
 		},
-		UserAgent: fmt.Sprintf("terraform-provider-rpaas/%s", Version),
 	}
 
-	if opts.URL != "" && opts.Username != "" && opts.Password != "" {
-		cfg.HTTPClient.Transport = &rpaasclient.BasicAuthTransport{
+	if useBasicAuth(opts) {
+		return &rpaasclient.BasicAuthTransport{
 			Username: opts.Username,
 			Password: opts.Password,
-			Base:     cfg.HTTPClient.Transport,
+			Base:     transport,
 		}
-	} else if opts.TsuruTarget != "" {
-		cfg.HTTPClient.Transport = &tsuruclient.TsuruProxyTransport{
+	}
+
+	if opts.TsuruTarget != "" && opts.tokenSource != nil {
+		transport = &tsuruTokenTransport{
+			Source: opts.tokenSource,
+			Base:   transport,
+		}
+	}
+
+	return transport
+}
+
+// newAutogeneratedClient creates a client for the service instance on top
+// of a transport built by newSharedTransport.
+func newAutogeneratedClient(opts *ProviderConfigOptions, transport http.RoundTripper, service, instance string) *autogenerated.APIClient {
+	serverURL := opts.URL
+	if serverURL == "" {
+		serverURL = opts.TsuruTarget
+	}
+
+	if !useBasicAuth(opts) && opts.TsuruTarget != "" {
+		transport = &tsuruclient.TsuruProxyTransport{
 			Target:   opts.TsuruTarget,
 			Token:    opts.TsuruToken,
-			Service:  opts.TsuruService,
-			Instance: opts.TsuruInstance,
-			Base:     cfg.HTTPClient.Transport,
+			Service:  service,
+			Instance: instance,
+			Base:     transport,
 		}
 	}
 
-	return autogenerated.NewAPIClient(cfg)
+	return autogenerated.NewAPIClient(&autogenerated.Configuration{
+		Servers: autogenerated.ServerConfigurations{{URL: serverURL}},
+		HTTPClient: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+		},
+		UserAgent: fmt.Sprintf("terraform-provider-rpaas/%s", Version),
+	})
+}
+
+func useBasicAuth(opts *ProviderConfigOptions) bool {
+	return opts.URL != "" && opts.Username != "" && opts.Password != ""
 }
 
 func rpaasRetry(ctx context.Context, timeout time.Duration, retryFunc func() (*http.Response, error)) error {
+	span := trace.SpanFromContext(ctx)
+
+	var retries int
 	return retry.RetryContext(ctx, timeout, func() *retry.RetryError {
 		response, err := retryFunc()
 		if err != nil && tsuruclient.IsTsuruEventLocked(response, err) {
+			retries++
+			span.AddEvent("Retrying: tsuru event locked", trace.WithAttributes(attribute.String("error", err.Error())))
+			span.SetAttributes(attribute.Int("rpaas.retry_count", retries))
 			return retry.RetryableError(err)
 		}
 
@@ -264,7 +503,8 @@ func rpaasRetry(ctx context.Context, timeout time.Duration, retryFunc func() (*h
 }
 
 func parseRpaasInstanceID(id string) (serviceName, instance string, err error) {
-	parts := strings.Split(id, "::")
+	parts := splitID(id)
+
 	if len(parts) != 2 {
 		serviceName, instance, err = parseRpaasInstanceID_legacyV0(id)
 		if err != nil {
@@ -285,7 +525,16 @@ func parseRpaasInstanceID_legacyV0(id string) (serviceName, instance string, err
 	return parts[0], parts[1], nil
 }
 
-func baseHTTPTransport(insecure bool) http.RoundTripper {
+func baseHTTPTransport(opts *ProviderConfigOptions) http.RoundTripper {
+	// each transport gets its own copy, as net/http changes it when setting
+	// up HTTP/2
+	tlsConfig := opts.tlsConfig.Clone()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		}
+	}
+
 	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -297,8 +546,6 @@ func baseHTTPTransport(insecure bool) http.RoundTripper {
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: insecure,
-		},
+		TLSClientConfig:       tlsConfig,
 	}
 }
@@ -5,6 +5,7 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	cmv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
@@ -31,6 +33,19 @@ var testAccProviderFactories = map[string]func() (*schema.Provider, error){
 	},
 }
 
+// testAccProtoV6ProviderFactories serve the whole provider, with the data
+// sources and functions written with the plugin framework.
+var testAccProtoV6ProviderFactories = map[string]func() (tfprotov6.ProviderServer, error){
+	"rpaas": func() (tfprotov6.ProviderServer, error) {
+		providerServer, err := ProviderServer(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		return providerServer(), nil
+	},
+}
+
 func TestProvider(t *testing.T) {
 	provider := Provider()
 	require.NoError(t, provider.InternalValidate(), "failed to validate internal provider")
@@ -69,21 +84,23 @@ func setupTestRpaasServer(t *testing.T) (*web.Api, *rpaasProvider) {
 		Password: "admin",
 	}
 
-	legacyClient, err := getLegacyClient(providerOpts)
-	require.NoError(t, err)
-
 	provider := &rpaasProvider{
-		RpaasClient: legacyClient,
-		opts:        providerOpts,
+		opts: providerOpts,
 	}
 
 	return server, provider
 }
 
+// setupTestAPIServer returns a client of the fake RPaaS API, used by tests
+// to set up and check the instances the provider works on.
 func setupTestAPIServer(t *testing.T) (client.Client, *web.Api) {
 	t.Helper()
 	server, provider := setupTestRpaasServer(t)
-	return provider.RpaasClient, server
+
+	rpaasClient, err := client.NewClientWithOptions(provider.opts.URL, provider.opts.Username, provider.opts.Password, client.ClientOptions{})
+	require.NoError(t, err)
+
+	return rpaasClient, server
 }
 
 func testAccPreCheck(t *testing.T) {
@@ -0,0 +1,128 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// requestLimiter limits the requests sent to RPaaS and Tsuru APIs by every
+// client of the provider: the rate of new requests and how many of them may
+// be in flight at once.
+type requestLimiter struct {
+	rate  *rate.Limiter
+	slots chan struct{}
+}
+
+// newRequestLimiter returns nil when there are no limits. Zero means no
+// limit on either setting.
+func newRequestLimiter(requestsPerSecond float64, concurrentRequests int) *requestLimiter {
+	if requestsPerSecond <= 0 && concurrentRequests <= 0 {
+		return nil
+	}
+
+	l := &requestLimiter{}
+	if requestsPerSecond > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+	if concurrentRequests > 0 {
+		l.slots = make(chan struct{}, concurrentRequests)
+	}
+
+	return l
+}
+
+// Acquire waits until a request may be sent. The returned function must be
+// called once the request is done.
+func (l *requestLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}
+
+	if l.rate != nil {
+		if err = l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+var _ http.RoundTripper = (*rateLimitTransport)(nil)
+
+// rateLimitTransport holds a slot of the limiter while the response body is
+// read, so a request counts as in flight until then. The slot is released
+// once the body hits EOF or is closed, or at the latest when the request
+// context is done, so responses dropped without closing their body do not
+// hold slots forever.
+type rateLimitTransport struct {
+	Limiter *requestLimiter
+	Base    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	release, err := t.Limiter.Acquire(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.Base.RoundTrip(r)
+	if err != nil || response.Body == nil || response.Body == http.NoBody {
+		release()
+		return response, err
+	}
+
+	stop := context.AfterFunc(r.Context(), release)
+	response.Body = &releasingReadCloser{
+		ReadCloser: response.Body,
+		release: func() {
+			stop()
+			release()
+		},
+	}
+	return response, nil
+}
+
+type releasingReadCloser struct {
+	io.ReadCloser
+
+	release func()
+}
+
+func (r *releasingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.release()
+	}
+	return n, err
+}
+
+func (r *releasingReadCloser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLimiter_MaxConcurrentRequests(t *testing.T) {
+	var inFlight, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			p := atomic.LoadInt32(&peak)
+			if current <= p || atomic.CompareAndSwapInt32(&peak, p, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/block") {
+			w.Write([]byte(`{"blocks": []}`))
+			return
+		}
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("RPAAS_MAX_REQUESTS_PER_SECOND", "")
+	t.Setenv("RPAAS_MAX_CONCURRENT_REQUESTS", "")
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"rpaas_url":               server.URL,
+		"max_concurrent_requests": 2,
+	})
+	opts, err := getProviderConfigOpts(context.Background(), d)
+	require.NoError(t, err)
+	require.NotNil(t, opts.requestLimiter)
+
+	provider := &rpaasProvider{opts: opts}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _, err := provider.Client("rpaasv2", "my-rpaas").RpaasApi.GetAutoscale(context.Background(), "my-rpaas").Execute()
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, err := provider.Client("rpaasv2", "other-rpaas").ListBlocks(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&peak))
+}
+
+func TestRequestLimiter_MaxRequestsPerSecond(t *testing.T) {
+	limiter := newRequestLimiter(20, 0)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		release, err := limiter.Acquire(context.Background())
+		require.NoError(t, err)
+		release()
+	}
+
+	// the first request is sent right away, the others every 50ms
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+}
+
+func TestRequestLimiter_Unlimited(t *testing.T) {
+	assert.Nil(t, newRequestLimiter(0, 0))
+
+	var limiter *requestLimiter
+	release, err := limiter.Acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestRequestLimiter_Canceled(t *testing.T) {
+	limiter := newRequestLimiter(0, 1)
+
+	release, err := limiter.Acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// releasing more than once frees a single slot
+	release()
+	release()
+
+	release, err = limiter.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimitTransport_BodyNotClosed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &rateLimitTransport{
+			Limiter: newRequestLimiter(0, 1),
+			Base:    http.DefaultTransport,
+		},
+	}
+
+	get := func(ctx context.Context, path string) (*http.Response, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		return client.Do(request)
+	}
+
+	// requests waiting for a slot fail instead of hanging the test
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// read until EOF, never closed
+	response, err := get(ctx, "/error")
+	require.NoError(t, err)
+	_, err = io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	// not read nor closed, dropped when its context is done
+	dropCtx, drop := context.WithCancel(ctx)
+	_, err = get(dropCtx, "/")
+	require.NoError(t, err)
+	drop()
+
+	// failed requests
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:0/", nil)
+	require.NoError(t, err)
+	_, err = client.Do(request)
+	require.Error(t, err)
+
+	response, err = get(ctx, "/")
+	require.NoError(t, err, "no slot left")
+	response.Body.Close()
+}
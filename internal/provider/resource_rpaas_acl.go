@@ -15,24 +15,27 @@ import (
 
 func resourceRpaasACL() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasACLCreate,
-		ReadContext:   resourceRpaasACLRead,
-		DeleteContext: resourceRpaasACLDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasACLCreate,
+		ReadContext:    resourceRpaasACLRead,
+		DeleteContext:  resourceRpaasACLDelete,
+		CustomizeDiff:  resolveServiceInstanceDefaults,
+		Importer:       resourceRpaasACLImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasACLV0Type(), resourceRpaasACLStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"host": {
 				Type:     schema.TypeString,
@@ -59,11 +62,6 @@ func resourceRpaasACLCreate(ctx context.Context, d *schema.ResourceData, meta in
 	host := d.Get("host").(string)
 	port := d.Get("port").(int)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Create ACL", map[string]interface{}{
 		"service":  serviceName,
 		"instance": instance,
@@ -71,15 +69,16 @@ func resourceRpaasACLCreate(ctx context.Context, d *schema.ResourceData, meta in
 		"port":     port,
 	})
 
-	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, rpaasClient.AddAccessControlList(ctx, instance, host, port)
+	err := rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
+		return provider.Client(serviceName, instance).AddAccessControlList(ctx, host, port)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to create ACL for instance %s: %v", instance, err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s::%s::%d", serviceName, instance, host, port))
+	d.SetId(buildID(serviceName, instance, host, strconv.Itoa(port)))
 	return resourceRpaasACLRead(ctx, d, meta)
 }
 
@@ -90,26 +89,28 @@ func resourceRpaasACLRead(ctx context.Context, d *schema.ResourceData, meta inte
 	if err != nil {
 		return diag.Errorf("Unable to parse ACL ID: %v", err)
 	}
-	d.SetId(fmt.Sprintf("%s::%s::%s::%d", serviceName, instance, host, port))
+	d.SetId(buildID(serviceName, instance, host, strconv.Itoa(port)))
 
 	provider := meta.(*rpaasProvider)
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	var acls []types.AllowedUpstream
 
-	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		a, nerr := rpaasClient.ListAccessControlList(ctx, instance)
+	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
+		a, response, nerr := cachedRead(ctx, provider.cache, serviceName, instance, "acls", func(ctx context.Context) ([]types.AllowedUpstream, *http.Response, error) {
+			return provider.Client(serviceName, instance).ListAccessControlList(ctx)
+		})
 		if nerr != nil {
-			return nil, nil
+			return response, nerr
 		}
 
 		acls = a
-		return nil, nil
+		return response, nil
 	})
 
+	if isNotFoundError(err) {
+		d.SetId("")
+		return nil
+	}
+
 	if err != nil {
 		return diag.Errorf("Unable to list ACL for instance %s: %v", instance, err)
 	}
@@ -138,11 +139,6 @@ func resourceRpaasACLDelete(ctx context.Context, d *schema.ResourceData, meta in
 	}
 
 	provider := meta.(*rpaasProvider)
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete ACL", map[string]interface{}{
 		"id":       d.Id(),
 		"service":  serviceName,
@@ -152,8 +148,9 @@ func resourceRpaasACLDelete(ctx context.Context, d *schema.ResourceData, meta in
 	})
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
-		return nil, rpaasClient.RemoveAccessControlList(ctx, instance, host, port)
+		return provider.Client(serviceName, instance).RemoveAccessControlList(ctx, host, port)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to delete ACL for instance %s: %v", instance, err)
@@ -163,9 +160,9 @@ func resourceRpaasACLDelete(ctx context.Context, d *schema.ResourceData, meta in
 }
 
 func parseACLID(id string) (serviceName string, instance string, host string, port int, err error) {
-	splitID := strings.Split(id, "::")
+	parts := splitID(id)
 
-	if len(splitID) != 4 {
+	if len(parts) != 4 {
 		serviceName, instance, host, port, err = parseACLID_legacyV0(id)
 		if err != nil {
 			err = fmt.Errorf("Could not parse id %q. Format should be \"service::instance::host::port\"", id)
@@ -173,10 +170,10 @@ func parseACLID(id string) (serviceName string, instance string, host string, po
 		return
 	}
 
-	serviceName = splitID[0]
-	instance = splitID[1]
-	host = splitID[2]
-	if port, err = strconv.Atoi(splitID[3]); err != nil {
+	serviceName = parts[0]
+	instance = parts[1]
+	host = parts[2]
+	if port, err = strconv.Atoi(parts[3]); err != nil {
 		err = fmt.Errorf("Resource id %q has a wrong format. Format should be \"service::instance::host::port\" (port must be integer).", id)
 	}
 	return
@@ -202,3 +199,43 @@ func parseACLID_legacyV0(id string) (serviceName string, instance string, host s
 
 	return parts1[0], parts1[1], parts2[0], port, nil
 }
+
+var rpaasACLID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "host", "port"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, host, port, err := parseACLID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"service_name": serviceName, "instance": instance, "host": host, "port": strconv.Itoa(port)}, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildID(a["service_name"], a["instance"], a["host"], a["port"])
+	},
+}
+
+func resourceRpaasACLImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_acl",
+		Keys:     []string{"host", "port"},
+		ParseID:  rpaasACLID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			acls, _, err := provider.Client(serviceName, instance).ListAccessControlList(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, acl := range acls {
+				candidates = append(candidates, importCandidate{
+					ID:         buildID(serviceName, instance, acl.Host, strconv.Itoa(acl.Port)),
+					Attributes: map[string]string{"host": acl.Host, "port": strconv.Itoa(acl.Port)},
+				})
+			}
+			return candidates, nil
+		},
+	}
+
+	return importer.Importer()
+}
@@ -6,79 +6,121 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/autogenerated"
 )
 
 func resourceRpaasAutoscale() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasAutoscaleCreate,
-		ReadContext:   resourceRpaasAutoscaleRead,
-		UpdateContext: resourceRpaasAutoscaleUpdate,
-		DeleteContext: resourceRpaasAutoscaleDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasAutoscaleCreate,
+		ReadContext:    resourceRpaasAutoscaleRead,
+		UpdateContext:  resourceRpaasAutoscaleUpdate,
+		DeleteContext:  resourceRpaasAutoscaleDelete,
+		CustomizeDiff:  customdiff.Sequence(resolveServiceInstanceDefaults, resourceRpaasAutoscaleCustomizeDiff),
+		Importer:       resourceRpaasAutoscaleImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasAutoscaleV0Type(), resourceStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"min_replicas": {
-				Type:        schema.TypeInt,
-				Required:    true,
-				Description: "Minimum number of replicas",
+				Type:         schema.TypeInt,
+				Required:     true,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "Minimum number of replicas. It cannot be greater than `max_replicas`.",
 			},
 			"max_replicas": {
-				Type:        schema.TypeInt,
-				Required:    true,
-				Description: "Maximum number of replicas",
+				Type:         schema.TypeInt,
+				Required:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Maximum number of replicas",
 			},
 			"target_cpu_utilization_percentage": {
-				Type:        schema.TypeInt,
-				Optional:    true,
-				Description: "Target average CPU utilization (represented as a percentage of requested CPU) over all the pods.",
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(1, 100),
+				Description:  "Target average CPU utilization (represented as a percentage of requested CPU) over all the pods, from 1 to 100.",
 			},
 			"target_requests_per_second": {
-				Type:        schema.TypeInt,
-				Optional:    true,
-				Description: "Target average of HTTP requests per second over the serving pods",
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Target average of HTTP requests per second over the serving pods",
 			},
 			"scheduled_window": {
 				Type: schema.TypeList,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
+						"min_replicas": {
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "Min number of running pods while this window is active. It cannot be greater than `max_replicas`.",
+						},
+						"start": {
+							Type:             schema.TypeString,
+							Required:         true,
+							ValidateDiagFunc: validateCronExpression,
+							Description:      "A Cron expression defining the start of the scheduled window, with the five fields minute, hour, day of month, month and day of week, as parsed by KEDA and the RPaaS API. Six-field expressions, such as `00 20 * * * 1-5`, are rejected at plan time. Example: `00 20 * * 1-5`.",
+						},
+						"end": {
+							Type:             schema.TypeString,
+							Required:         true,
+							ValidateDiagFunc: validateCronExpression,
+							Description:      "A Cron expression defining the end of the scheduled window, in the same format as `start`. Each start must be followed by an end before the window starts again. Example: `00 00 * * 2-6`.",
+						},
+					},
+				},
+				Optional:    true,
+				Description: "Scheduled windows are recurring (or not) time windows where the instance can scale in/out your min replicas regardless of traffic or resource utilization. At least one scheduled window or target is required. Windows cannot overlap. Their cron expressions are evaluated in the default timezone of the KEDA options of the instance, UTC unless set: the RPaaS API does not store a timezone per window. The RPaaS API does not report that default either, so overlaps and the order of starts and ends are checked in UTC.",
+			},
+			"next_activations": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"scheduled_window": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Index of the scheduled window in `scheduled_window`.",
+						},
 						"min_replicas": {
 							Type:        schema.TypeInt,
-							Required:    true,
-							Description: "Min number of running pods while this window is active. It cannot be greater than `max_replicas`.",
+							Computed:    true,
+							Description: "Min number of running pods while the window is active.",
 						},
 						"start": {
 							Type:        schema.TypeString,
-							Required:    true,
-							Description: "An Cron expression defining the start of the scheduled window. Example: `00 20 * * * 1-5`.",
+							Computed:    true,
+							Description: "Start time of the activation, in RFC 3339 format.",
 						},
 						"end": {
 							Type:        schema.TypeString,
-							Required:    true,
-							Description: "An Cron expression defining the end of the scheduled window. Example: `00 00 * * * 1-5`.",
+							Computed:    true,
+							Description: "End time of the activation, in RFC 3339 format.",
 						},
 					},
 				},
-				Optional:    true,
-				Description: "Scheduled windows are recurring (or not) time windows where the instance can scale in/out your min replicas regardless of traffic or resource utilization.",
+				Description: fmt.Sprintf("Preview of the next %d activations of the scheduled windows, sorted by start time, as of the last refresh. Computed in UTC, so it is off by the offset of the default timezone of the instance when one is set in its KEDA options.", nextActivationsCount),
 			},
 		},
 	}
@@ -105,12 +147,13 @@ func resourceRpaasAutoscaleCreate(ctx context.Context, d *schema.ResourceData, m
 	err := rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
 		return provider.Client(service, instance).RpaasApi.UpdateAutoscale(ctx, instance).Autoscale(autoscale).Execute()
 	})
+	provider.cache.Invalidate(service, instance)
 
 	if err != nil {
 		return diag.Errorf("could not update the autoscale config on RPaaS: %s", err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s", service, instance))
+	d.SetId(buildID(service, instance))
 	return nil
 }
 
@@ -123,7 +166,7 @@ func resourceRpaasAutoscaleRead(ctx context.Context, d *schema.ResourceData, met
 	d.Set("service_name", service)
 	d.Set("instance", instance)
 
-	d.SetId(fmt.Sprintf("%s::%s", service, instance)) // ensure the new ID format
+	d.SetId(buildID(service, instance)) // ensure the new ID format
 
 	provider, ok := meta.(*rpaasProvider)
 	if !ok {
@@ -173,6 +216,18 @@ func resourceRpaasAutoscaleRead(ctx context.Context, d *schema.ResourceData, met
 
 	d.Set("scheduled_window", sws)
 
+	var activations []any
+	for _, a := range nextActivations(scheduledWindowsOf(sws), scheduleNow().UTC(), nextActivationsCount) {
+		activations = append(activations, map[string]any{
+			"scheduled_window": a.Window,
+			"min_replicas":     a.MinReplicas,
+			"start":            formatScheduleTime(a.Start),
+			"end":              formatScheduleTime(a.End),
+		})
+	}
+
+	d.Set("next_activations", activations)
+
 	return nil
 }
 
@@ -192,6 +247,7 @@ func resourceRpaasAutoscaleUpdate(ctx context.Context, d *schema.ResourceData, m
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
 		return provider.Client(service, instance).RpaasApi.UpdateAutoscale(ctx, instance).Autoscale(autoscale).Execute()
 	})
+	provider.cache.Invalidate(service, instance)
 
 	if err != nil {
 		return diag.Errorf("could not update the autoscale config on RPaaS API: %s", err)
@@ -214,6 +270,7 @@ func resourceRpaasAutoscaleDelete(ctx context.Context, d *schema.ResourceData, m
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
 		return provider.Client(service, instance).RpaasApi.RemoveAutoscale(ctx, instance).Execute()
 	})
+	provider.cache.Invalidate(service, instance)
 
 	if err != nil {
 		return diag.Errorf("could not remove the autoscale config from RPaaS API: %s", err)
@@ -223,6 +280,88 @@ func resourceRpaasAutoscaleDelete(ctx context.Context, d *schema.ResourceData, m
 	return nil
 }
 
+// resourceRpaasAutoscaleCustomizeDiff checks at plan time the constraints
+// across attributes the RPaaS API would only reject on apply. Values unknown
+// until apply are not checked.
+func resourceRpaasAutoscaleCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	known := func(keys ...string) bool {
+		for _, k := range keys {
+			if !d.NewValueKnown(k) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var errs []error
+	maxReplicas := d.Get("max_replicas").(int)
+
+	if minReplicas := d.Get("min_replicas").(int); known("min_replicas", "max_replicas") && minReplicas > maxReplicas {
+		errs = append(errs, fmt.Errorf("\"min_replicas\" (%d) cannot be greater than \"max_replicas\" (%d)", minReplicas, maxReplicas))
+	}
+
+	windows := d.Get("scheduled_window").([]any)
+	if known("target_cpu_utilization_percentage", "target_requests_per_second", "scheduled_window") {
+		_, cpu := d.GetOk("target_cpu_utilization_percentage")
+		_, rps := d.GetOk("target_requests_per_second")
+		if !cpu && !rps && len(windows) == 0 {
+			errs = append(errs, errors.New("At least one of \"target_cpu_utilization_percentage\", \"target_requests_per_second\" or \"scheduled_window\" is required"))
+		}
+	}
+
+	var parsed []scheduledWindow
+	for i := range windows {
+		key := fmt.Sprintf("scheduled_window.%d.min_replicas", i)
+		if minReplicas := d.Get(key).(int); known(key, "max_replicas") && minReplicas > maxReplicas {
+			errs = append(errs, fmt.Errorf("%q (%d) cannot be greater than \"max_replicas\" (%d)", key, minReplicas, maxReplicas))
+		}
+
+		start, end := fmt.Sprintf("scheduled_window.%d.start", i), fmt.Sprintf("scheduled_window.%d.end", i)
+		if !known(start, end) {
+			continue
+		}
+
+		// empty windows pass the checks below, but the RPaaS API rejects them
+		if d.Get(start).(string) == d.Get(end).(string) {
+			errs = append(errs, fmt.Errorf("%q and %q cannot have exactly the same cron expression (%q)", start, end, d.Get(start).(string)))
+			continue
+		}
+
+		// invalid expressions are reported by their validation
+		if w, err := parseScheduledWindow(i, d.Get(key).(int), d.Get(start).(string), d.Get(end).(string)); err == nil {
+			parsed = append(parsed, w)
+		}
+	}
+
+	if err := checkScheduledWindows(parsed, scheduleNow().UTC()); err != nil {
+		errs = append(errs, err)
+	}
+
+	if d.HasChange("scheduled_window") {
+		// the activations are previewed from the time of the refresh after
+		// apply
+		if err := d.SetNewComputed("next_activations"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// scheduledWindowsOf parses the scheduled_window attribute, skipping the
+// windows with invalid expressions.
+func scheduledWindowsOf(v []any) []scheduledWindow {
+	var windows []scheduledWindow
+	for i, sw := range v {
+		s := sw.(map[string]any)
+		w, err := parseScheduledWindow(i, s["min_replicas"].(int), s["start"].(string), s["end"].(string))
+		if err == nil {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
 func extractAutoscaleFromState(d *schema.ResourceData) (a autogenerated.Autoscale) {
 	if v, ok := d.GetOk("min_replicas"); ok {
 		a.MinReplicas = int32(v.(int))
@@ -275,3 +414,42 @@ func asScheduledWindows(v []any) (sws []autogenerated.ScheduledWindow) {
 
 	return
 }
+
+var rpaasAutoscaleID = resourceIDFormat{
+	Required: []string{"service_name", "instance"},
+	Parse: func(id string) (map[string]string, error) {
+		service, instance, err := parseRpaasInstanceID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"service_name": service, "instance": instance}, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildID(a["service_name"], a["instance"])
+	},
+}
+
+func resourceRpaasAutoscaleImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_autoscale",
+		ParseID:  rpaasAutoscaleID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			autoscale, response, err := provider.Client(serviceName, instance).RpaasApi.GetAutoscale(ctx, instance).Execute()
+			if response != nil && response.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if autoscale == nil {
+				return nil, nil
+			}
+
+			return []importCandidate{{ID: buildID(serviceName, instance)}}, nil
+		},
+	}
+
+	return importer.Importer()
+}
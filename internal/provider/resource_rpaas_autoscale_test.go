@@ -6,8 +6,11 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 	"github.com/stretchr/testify/assert"
@@ -176,3 +179,223 @@ func TestAccRpaasAutoscale_import(t *testing.T) {
 		},
 	})
 }
+
+// planTestAutoscale plans the creation of an autoscale with the attributes
+// given, as Terraform does on PlanResourceChange.
+func planTestAutoscale(t *testing.T, values map[string]cty.Value) error {
+	t.Helper()
+
+	r := resourceRpaasAutoscale()
+	values["service_name"] = cty.StringVal("rpaasv2")
+	values["instance"] = cty.StringVal("my-rpaas")
+	state := &terraform.InstanceState{RawConfig: testRawObject(r, values)}
+
+	config := terraform.NewResourceConfigShimmed(state.RawConfig, r.CoreConfigSchema())
+	if diags := r.Validate(config); diags.HasError() {
+		return fmt.Errorf("%s", diags[0].Summary)
+	}
+
+	_, err := r.SimpleDiff(context.Background(), state, config, &rpaasProvider{opts: &ProviderConfigOptions{}})
+	return err
+}
+
+// testScheduledWindows returns a window with each min_replicas given, on
+// consecutive days of the week from Monday.
+func testScheduledWindows(minReplicas ...int64) cty.Value {
+	windows := make([]cty.Value, 0, len(minReplicas))
+	for i, m := range minReplicas {
+		windows = append(windows, testScheduledWindow(m, fmt.Sprintf("00 08 * * %d", i+1), fmt.Sprintf("00 20 * * %d", i+1)))
+	}
+	return cty.ListVal(windows)
+}
+
+func testScheduledWindow(minReplicas int64, start, end string) cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"min_replicas": cty.NumberIntVal(minReplicas),
+		"start":        cty.StringVal(start),
+		"end":          cty.StringVal(end),
+	})
+}
+
+func TestResourceRpaasAutoscaleCustomizeDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        map[string]cty.Value
+		expectedError string
+	}{
+		{
+			name: "valid",
+			values: map[string]cty.Value{
+				"min_replicas":                      cty.NumberIntVal(0),
+				"max_replicas":                      cty.NumberIntVal(10),
+				"target_cpu_utilization_percentage": cty.NumberIntVal(100),
+				"scheduled_window":                  testScheduledWindows(10),
+			},
+		},
+		{
+			name: "only scheduled windows",
+			values: map[string]cty.Value{
+				"min_replicas":     cty.NumberIntVal(1),
+				"max_replicas":     cty.NumberIntVal(10),
+				"scheduled_window": testScheduledWindows(5),
+			},
+		},
+		{
+			name: "min replicas greater than max replicas",
+			values: map[string]cty.Value{
+				"min_replicas":               cty.NumberIntVal(5),
+				"max_replicas":               cty.NumberIntVal(3),
+				"target_requests_per_second": cty.NumberIntVal(100),
+			},
+			expectedError: `"min_replicas" (5) cannot be greater than "max_replicas" (3)`,
+		},
+		{
+			name: "unknown max replicas",
+			values: map[string]cty.Value{
+				"min_replicas":               cty.NumberIntVal(5),
+				"max_replicas":               cty.UnknownVal(cty.Number),
+				"target_requests_per_second": cty.NumberIntVal(100),
+				"scheduled_window":           testScheduledWindows(20),
+			},
+		},
+		{
+			name: "negative min replicas",
+			values: map[string]cty.Value{
+				"min_replicas":               cty.NumberIntVal(-1),
+				"max_replicas":               cty.NumberIntVal(3),
+				"target_requests_per_second": cty.NumberIntVal(100),
+			},
+			expectedError: `expected min_replicas to be at least (0), got -1`,
+		},
+		{
+			name: "CPU percentage over 100",
+			values: map[string]cty.Value{
+				"min_replicas":                      cty.NumberIntVal(1),
+				"max_replicas":                      cty.NumberIntVal(3),
+				"target_cpu_utilization_percentage": cty.NumberIntVal(150),
+			},
+			expectedError: `expected target_cpu_utilization_percentage to be in the range (1 - 100), got 150`,
+		},
+		{
+			name: "no targets",
+			values: map[string]cty.Value{
+				"min_replicas": cty.NumberIntVal(1),
+				"max_replicas": cty.NumberIntVal(3),
+			},
+			expectedError: `At least one of "target_cpu_utilization_percentage", "target_requests_per_second" or "scheduled_window" is required`,
+		},
+		{
+			name: "unknown targets",
+			values: map[string]cty.Value{
+				"min_replicas":                      cty.NumberIntVal(1),
+				"max_replicas":                      cty.NumberIntVal(3),
+				"target_cpu_utilization_percentage": cty.UnknownVal(cty.Number),
+			},
+		},
+		{
+			name: "scheduled window min replicas greater than max replicas",
+			values: map[string]cty.Value{
+				"min_replicas":     cty.NumberIntVal(1),
+				"max_replicas":     cty.NumberIntVal(10),
+				"scheduled_window": testScheduledWindows(5, 20),
+			},
+			expectedError: `"scheduled_window.1.min_replicas" (20) cannot be greater than "max_replicas" (10)`,
+		},
+		{
+			name: "invalid cron expression",
+			values: map[string]cty.Value{
+				"min_replicas":     cty.NumberIntVal(1),
+				"max_replicas":     cty.NumberIntVal(10),
+				"scheduled_window": cty.ListVal([]cty.Value{testScheduledWindow(5, "00 20 * * * 1-5", "00 00 * * 2-6")}),
+			},
+			expectedError: `Invalid cron expression "00 20 * * * 1-5"`,
+		},
+		{
+			name: "end not following start",
+			values: map[string]cty.Value{
+				"min_replicas":     cty.NumberIntVal(1),
+				"max_replicas":     cty.NumberIntVal(10),
+				"scheduled_window": cty.ListVal([]cty.Value{testScheduledWindow(5, "00 08 * * *", "00 20 * * 1")}),
+			},
+			expectedError: `"end" must follow "start"`,
+		},
+		{
+			name: "end equal to start",
+			values: map[string]cty.Value{
+				"min_replicas":     cty.NumberIntVal(1),
+				"max_replicas":     cty.NumberIntVal(10),
+				"scheduled_window": cty.ListVal([]cty.Value{testScheduledWindow(5, "00 08 * * 1-5", "00 08 * * 1-5")}),
+			},
+			expectedError: `"scheduled_window.0.start" and "scheduled_window.0.end" cannot have exactly the same cron expression ("00 08 * * 1-5")`,
+		},
+		{
+			name: "overlapping windows",
+			values: map[string]cty.Value{
+				"min_replicas": cty.NumberIntVal(1),
+				"max_replicas": cty.NumberIntVal(10),
+				"scheduled_window": cty.ListVal([]cty.Value{
+					testScheduledWindow(5, "00 08 * * 1-5", "00 20 * * 1-5"),
+					testScheduledWindow(8, "00 19 * * 5", "00 01 * * 6"),
+				}),
+			},
+			expectedError: `"scheduled_window.0" and "scheduled_window.1" overlap from`,
+		},
+		{
+			name: "unknown cron expression",
+			values: map[string]cty.Value{
+				"min_replicas": cty.NumberIntVal(1),
+				"max_replicas": cty.NumberIntVal(10),
+				"scheduled_window": cty.ListVal([]cty.Value{
+					testScheduledWindow(5, "00 08 * * 1-5", "00 20 * * 1-5"),
+					cty.ObjectVal(map[string]cty.Value{
+						"min_replicas": cty.NumberIntVal(8),
+						"start":        cty.UnknownVal(cty.String),
+						"end":          cty.StringVal("00 20 * * 1-5"),
+					}),
+				}),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := planTestAutoscale(t, tt.values)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResourceRpaasAutoscaleRead_nextActivations(t *testing.T) {
+	server, provider := setupTestRpaasServer(t)
+	defer server.Stop()
+
+	oldScheduleNow := scheduleNow
+	scheduleNow = func() time.Time { return time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC) }
+	defer func() { scheduleNow = oldScheduleNow }()
+
+	_, err := provider.Client("rpaasv2", "my-rpaas").RpaasApi.UpdateAutoscale(context.Background(), "my-rpaas").
+		Autoscale(autogenerated.Autoscale{
+			MinReplicas: 1,
+			MaxReplicas: 10,
+			Schedules: []autogenerated.ScheduledWindow{
+				{MinReplicas: 5, Start: "00 08 * * 1-5", End: "00 20 * * 1-5"},
+				{MinReplicas: 2, Start: "00 10 * * 6", End: "00 22 * * 0"},
+			},
+		}).
+		Execute()
+	require.NoError(t, err)
+
+	d := resourceRpaasAutoscale().TestResourceData()
+	d.SetId("rpaasv2::my-rpaas")
+	diags := resourceRpaasAutoscaleRead(context.Background(), d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	activations := d.Get("next_activations").([]any)
+	require.Len(t, activations, nextActivationsCount)
+	assert.Equal(t, map[string]any{"scheduled_window": 0, "min_replicas": 5, "start": "2024-05-16T08:00:00Z", "end": "2024-05-16T20:00:00Z"}, activations[0])
+	assert.Equal(t, map[string]any{"scheduled_window": 1, "min_replicas": 2, "start": "2024-05-18T10:00:00Z", "end": "2024-05-19T22:00:00Z"}, activations[2])
+}
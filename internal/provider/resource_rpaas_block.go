@@ -13,8 +13,8 @@ import (
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	rpaas_client "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
 	rpaastypes "github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
 )
 
@@ -22,25 +22,28 @@ var validBlocks = []string{"root", "http", "server", "lua-server", "lua-worker"}
 
 func resourceRpaasBlock() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasBlockCreate,
-		ReadContext:   resourceRpaasBlockRead,
-		UpdateContext: resourceRpaasBlockUpdate,
-		DeleteContext: resourceRpaasBlockDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasBlockCreate,
+		ReadContext:    resourceRpaasBlockRead,
+		UpdateContext:  resourceRpaasBlockUpdate,
+		DeleteContext:  resourceRpaasBlockDelete,
+		CustomizeDiff:  customdiff.Sequence(resolveServiceInstanceDefaults, resourceRpaasBlockCustomizeDiff),
+		Importer:       resourceRpaasBlockImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasBlockV0Type(), resourceStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"server_name": {
 				Type:        schema.TypeString,
@@ -71,10 +74,44 @@ func resourceRpaasBlock() *schema.Resource {
 				Description: fmt.Sprintf("Name of the block that will receive the custom configuration content. Allowed values: %v", validBlocks),
 			},
 			"content": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ExactlyOneOf:     []string{"content", "template"},
+				DiffSuppressFunc: suppressEquivalentBlockContent,
+				Description:      "Custom Nginx configuration. Whitespace-only differences are ignored. Lua syntax is validated at plan time when `name` is `lua-server` or `lua-worker`, unless the content has Go template actions (`{{ ... }}`), which the operator renders first.",
+			},
+			"template": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"content", "template"},
+				Description:  "Custom Nginx configuration written as a Go `text/template`, rendered by the provider using `vars`. Example: `proxy_pass http://{{ .upstream }};`.",
+			},
+			"vars": {
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				RequiredWith: []string{"template"},
+				Description:  "Variables available to `template` and `includes`. Referencing a variable not defined here is an error.",
+			},
+			"includes": {
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				RequiredWith: []string{"template"},
+				Description:  "Named templates that can be included from `template` with `{{ template \"name\" . }}`. The name `template` is reserved.",
+			},
+			"ignore_comments": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether changes only on comments of the Nginx configuration should be ignored when comparing it against the content stored on RPaaS.",
+			},
+			"rendered_content": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Custom Nginx configuration",
+				Computed:    true,
+				Description: "Nginx configuration sent to the RPaaS API, either `content` or the rendered `template`.",
 			},
+			"wait_for_rollout": waitForRolloutSchema(),
 		},
 	}
 }
@@ -86,12 +123,11 @@ func resourceRpaasBlockCreate(ctx context.Context, d *schema.ResourceData, meta
 	serviceName := d.Get("service_name").(string)
 	serverName := d.Get("server_name").(string)
 	blockName := d.Get("name").(string)
-	content := d.Get("content").(string)
 	extend := d.Get("extend").(bool)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
+	content, err := resourceRpaasBlockContent(d.Get("content").(string), d.Get("template").(string), d.Get("includes").(map[string]interface{}), d.Get("vars").(map[string]interface{}))
 	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
+		return diag.Errorf("Unable to render block template: %v", err)
 	}
 
 	tflog.Info(ctx, "Create block", map[string]interface{}{
@@ -102,26 +138,33 @@ func resourceRpaasBlockCreate(ctx context.Context, d *schema.ResourceData, meta
 		"extend":     extend,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateBlock(ctx, rpaas_client.UpdateBlockArgs{
-			Instance:   instance,
+		return provider.Client(serviceName, instance).UpdateBlock(ctx, rpaastypes.Block{
 			Name:       blockName,
 			ServerName: serverName,
 			Content:    content,
 			Extend:     extend,
 		})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to create/update block %s for instance %s: %v", blockName, instance, err)
 	}
 
-	if serverName == "" {
-		d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, blockName))
-	} else {
-		d.SetId(fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, serverName, blockName))
+	d.SetId(buildRpaasBlockID(serviceName, instance, serverName, blockName))
+
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutCreate))
+	if diags.HasError() {
+		return diags
 	}
-	return resourceRpaasBlockRead(ctx, d, meta)
+
+	return append(diags, resourceRpaasBlockRead(ctx, d, meta)...)
 }
 
 func resourceRpaasBlockUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -132,12 +175,11 @@ func resourceRpaasBlockUpdate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("Unable to parse Block ID: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
+	content, err := resourceRpaasBlockContent(d.Get("content").(string), d.Get("template").(string), d.Get("includes").(map[string]interface{}), d.Get("vars").(map[string]interface{}))
 	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
+		return diag.Errorf("Unable to render block template: %v", err)
 	}
 
-	content := d.Get("content").(string)
 	extend := d.Get("extend").(bool)
 	tflog.Info(ctx, "Update block", map[string]interface{}{
 		"id":         d.Id(),
@@ -147,21 +189,31 @@ func resourceRpaasBlockUpdate(ctx context.Context, d *schema.ResourceData, meta
 		"name":       blockName,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateBlock(ctx, rpaas_client.UpdateBlockArgs{
-			Instance:   instance,
+		return provider.Client(serviceName, instance).UpdateBlock(ctx, rpaastypes.Block{
 			Name:       blockName,
 			ServerName: serverName,
 			Content:    content,
 			Extend:     extend,
 		})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to update block %s for instance %s: %v", blockName, instance, err)
 	}
 
-	return resourceRpaasBlockRead(ctx, d, meta)
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutUpdate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasBlockRead(ctx, d, meta)...)
 }
 
 func resourceRpaasBlockRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -172,28 +224,35 @@ func resourceRpaasBlockRead(ctx context.Context, d *schema.ResourceData, meta in
 		return diag.Errorf("Unable to parse Block ID: %v", err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, blockName))
+	d.SetId(buildRpaasBlockID(serviceName, instance, serverName, blockName))
 	d.Set("instance", instance)
 	d.Set("service_name", serviceName)
 	d.Set("server_name", serverName)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
+	// states written before ignore_comments existed, and imports, lack it
+	if _, ok := d.GetOk("ignore_comments"); !ok {
+		d.Set("ignore_comments", false)
 	}
 
 	var blocks []rpaastypes.Block
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
-		bs, nerr := rpaasClient.ListBlocks(ctx, rpaas_client.ListBlocksArgs{Instance: instance})
+		bs, response, nerr := cachedRead(ctx, provider.cache, serviceName, instance, "blocks", func(ctx context.Context) ([]rpaastypes.Block, *http.Response, error) {
+			return provider.Client(serviceName, instance).ListBlocks(ctx)
+		})
 		if nerr != nil {
-			return nil, nerr
+			return response, nerr
 		}
 
 		blocks = bs
-		return nil, nil
+		return response, nil
 	})
 
+	if isNotFoundError(err) {
+		d.SetId("")
+		return nil
+	}
+
 	if err != nil {
 		return diag.Errorf("Unable to get block %s for instance %s: %v", blockName, instance, err)
 	}
@@ -214,14 +273,14 @@ func resourceRpaasBlockRead(ctx context.Context, d *schema.ResourceData, meta in
 		}
 
 		d.Set("name", b.Name)
-		d.Set("content", b.Content)
+		d.Set("rendered_content", b.Content)
 		d.Set("extend", b.Extend)
 
-		if serverName == "" {
-			d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, blockName))
-		} else {
-			d.SetId(fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, serverName, blockName))
+		if _, ok := d.GetOk("template"); !ok {
+			d.Set("content", b.Content)
 		}
+
+		d.SetId(buildRpaasBlockID(serviceName, instance, serverName, blockName))
 		return nil
 	}
 
@@ -238,11 +297,6 @@ func resourceRpaasBlockDelete(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("Unable to parse Block ID: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete block", map[string]interface{}{
 		"id":         d.Id(),
 		"service":    serviceName,
@@ -251,33 +305,93 @@ func resourceRpaasBlockDelete(ctx context.Context, d *schema.ResourceData, meta
 		"name":       blockName,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
-		return nil, rpaasClient.DeleteBlock(ctx, rpaas_client.DeleteBlockArgs{
-			Instance:   instance,
-			Name:       blockName,
-			ServerName: serverName,
-		})
+		return provider.Client(serviceName, instance).DeleteBlock(ctx, serverName, blockName)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to remove block for instance %s: %v", instance, err)
 	}
 
-	return nil
+	return waiter.Wait(ctx, d.Timeout(schema.TimeoutDelete))
+}
+
+func resourceRpaasBlockCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range []string{"content", "template", "includes", "vars"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("rendered_content")
+		}
+	}
+
+	template := d.Get("template").(string)
+	content, err := resourceRpaasBlockContent(d.Get("content").(string), template, d.Get("includes").(map[string]interface{}), d.Get("vars").(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+
+	if isLuaBlock(d.Get("name").(string)) {
+		attribute := "content"
+		if template != "" {
+			attribute = "template"
+		}
+
+		if err = validateLuaBlock(attribute, content); err != nil {
+			return err
+		}
+	}
+
+	// drift on templated blocks is only noticeable by comparing the rendered
+	// template against the content read from the API
+	if template == "" && !d.HasChange("content") {
+		return nil
+	}
+
+	rendered := d.Get("rendered_content").(string)
+	if isLuaBlock(d.Get("name").(string)) {
+		if normalizeLuaCode(rendered) == normalizeLuaCode(content) {
+			return nil
+		}
+	} else if equivalentNginxConfig(rendered, content, d.Get("ignore_comments").(bool)) {
+		return nil
+	}
+
+	return d.SetNew("rendered_content", content)
+}
+
+func resourceRpaasBlockContent(content, template string, includes, vars map[string]interface{}) (string, error) {
+	if template == "" {
+		return content, nil
+	}
+
+	return renderTemplate(template, includes, vars)
+}
+
+func buildRpaasBlockID(serviceName, instance, serverName, blockName string) string {
+	if serverName == "" {
+		return buildID(serviceName, instance, blockName)
+	}
+
+	return buildID(serviceName, instance, serverName, blockName)
 }
 
 func parseRpaasBlockID(id string) (serviceName, instance, serverName, blockName string, err error) {
-	splitID := strings.Split(id, "::")
-
-	if len(splitID) == 3 {
-		serviceName = splitID[0]
-		instance = splitID[1]
-		blockName = splitID[2]
-	} else if len(splitID) == 4 {
-		serviceName = splitID[0]
-		instance = splitID[1]
-		serverName = splitID[2]
-		blockName = splitID[3]
+	parts := splitID(id)
+
+	if len(parts) == 3 {
+		serviceName = parts[0]
+		instance = parts[1]
+		blockName = parts[2]
+	} else if len(parts) == 4 {
+		serviceName = parts[0]
+		instance = parts[1]
+		serverName = parts[2]
+		blockName = parts[3]
 	} else {
 		serviceName, instance, blockName, err = parseRpaasBlockID_legacyV0(id)
 	}
@@ -297,3 +411,48 @@ func parseRpaasBlockID_legacyV0(id string) (serviceName, instance, blockName str
 	instance = splitID[1]
 	return
 }
+
+var rpaasBlockID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "name"},
+	Optional: []string{"server_name"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, serverName, blockName, err := parseRpaasBlockID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := map[string]string{"service_name": serviceName, "instance": instance, "server_name": serverName}
+		if blockName != "" { // legacy IDs have no block name
+			attributes["name"] = blockName
+		}
+		return attributes, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildRpaasBlockID(a["service_name"], a["instance"], a["server_name"], a["name"])
+	},
+}
+
+func resourceRpaasBlockImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_block",
+		Keys:     []string{"name", "server_name"},
+		ParseID:  rpaasBlockID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			blocks, _, err := provider.Client(serviceName, instance).ListBlocks(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, b := range blocks {
+				candidates = append(candidates, importCandidate{
+					ID:         buildRpaasBlockID(serviceName, instance, b.ServerName, b.Name),
+					Attributes: map[string]string{"name": b.Name, "server_name": b.ServerName},
+				})
+			}
+			return candidates, nil
+		},
+	}
+
+	return importer.Importer()
+}
@@ -7,6 +7,7 @@ package provider
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
@@ -173,9 +174,32 @@ func TestAccRpaasBlock_import(t *testing.T) {
 					assert.Equal(t, "my-rpaas", state.Attributes["instance"])
 					assert.Equal(t, "lua-worker", state.Attributes["name"])
 					assert.Equal(t, "imported", state.Attributes["content"])
+					assert.Equal(t, "false", state.Attributes["ignore_comments"])
 					return nil
 				},
 			},
+			{
+				// Testing Import by attributes
+				Config:        `resource "rpaas_block" "imported" {}`,
+				ResourceName:  "rpaas_block.imported",
+				ImportStateId: "service_name=rpaasv2-be,instance=my-rpaas,name=lua-worker",
+				ImportState:   true,
+				ImportStateCheck: func(s []*terraform.InstanceState) error {
+					state := s[0]
+					assert.Len(t, s, 1)
+					assert.Equal(t, "rpaasv2-be::my-rpaas::lua-worker", state.Attributes["id"])
+					assert.Equal(t, "imported", state.Attributes["content"])
+					return nil
+				},
+			},
+			{
+				// Testing Import of a missing block
+				Config:        `resource "rpaas_block" "imported" {}`,
+				ResourceName:  "rpaas_block.imported",
+				ImportStateId: "service_name=rpaasv2-be,instance=my-rpaas,name=server",
+				ImportState:   true,
+				ExpectError:   regexp.MustCompile(`no matching object found on instance my-rpaas. Candidates: "rpaasv2-be::my-rpaas::lua-worker"`),
+			},
 			{
 				// Testing Import legacy ID
 				Config:        `resource "rpaas_block" "imported" {}`,
@@ -193,6 +217,152 @@ func TestAccRpaasBlock_import(t *testing.T) {
 	})
 }
 
+func TestAccRpaasBlock_template(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_block.templated"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccRpaasBlockConfigWithTemplate("app.tsuru.io"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "id", "rpaasv2-be::my-rpaas::server"),
+					resource.TestCheckNoResourceAttr(resourceName, "content"),
+					resource.TestCheckResourceAttr(resourceName, "rendered_content", "location /app {\n  proxy_set_header X-Env prod;\n  proxy_pass http://app.tsuru.io;\n}\n"),
+					func(s *terraform.State) error {
+						blocks, err := testAPIClient.ListBlocks(context.Background(), client.ListBlocksArgs{Instance: "my-rpaas"})
+						assert.NoError(t, err)
+						assert.Len(t, blocks, 1)
+						assert.Equal(t, "location /app {\n  proxy_set_header X-Env prod;\n  proxy_pass http://app.tsuru.io;\n}\n", blocks[0].Content)
+						return nil
+					},
+				),
+			},
+			{
+				// Testing Update - template vars
+				Config: testAccRpaasBlockConfigWithTemplate("other.tsuru.io"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "rendered_content", "location /app {\n  proxy_set_header X-Env prod;\n  proxy_pass http://other.tsuru.io;\n}\n"),
+					func(s *terraform.State) error {
+						blocks, err := testAPIClient.ListBlocks(context.Background(), client.ListBlocksArgs{Instance: "my-rpaas"})
+						assert.NoError(t, err)
+						assert.Len(t, blocks, 1)
+						assert.Equal(t, "location /app {\n  proxy_set_header X-Env prod;\n  proxy_pass http://other.tsuru.io;\n}\n", blocks[0].Content)
+						return nil
+					},
+				),
+			},
+			{
+				// Testing drift - content changed outside of Terraform
+				PreConfig: func() {
+					err := testAPIClient.UpdateBlock(context.Background(), client.UpdateBlockArgs{Instance: "my-rpaas", Name: "server", Content: "# changed manually"})
+					assert.NoError(t, err)
+				},
+				Config:             testAccRpaasBlockConfigWithTemplate("other.tsuru.io"),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+func TestAccRpaasBlock_luaSyntax(t *testing.T) {
+	_, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_block.custom_block_server"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccRpaasBlockConfig("lua-server", "local x =\n\tfunction foo() end"),
+				ExpectError: regexp.MustCompile(`Invalid Lua code in "content" \(line 2, column 10\): syntax error near 'foo'`),
+			},
+			{
+				Config: testAccRpaasBlockConfig("lua-server", "local x = 1"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "name", "lua-server"),
+					resource.TestCheckResourceAttr(resourceName, "content", "local x = 1\n"),
+				),
+			},
+			{
+				// rendered by the operator before it is Lua
+				Config: testAccRpaasBlockConfig("lua-server", `local name = {{ printf "%q" .Name }}`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "content", "local name = {{ printf \"%q\" .Name }}\n"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccRpaasBlock_providerDefaults(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_block.custom_block_server"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccRpaasBlockConfigWithProviderDefaults("rpaasv2-be", "my-rpaas", ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "id", "rpaasv2-be::my-rpaas::http"),
+					resource.TestCheckResourceAttr(resourceName, "service_name", "rpaasv2-be"),
+					resource.TestCheckResourceAttr(resourceName, "instance", "my-rpaas"),
+					func(s *terraform.State) error {
+						blocks, err := testAPIClient.ListBlocks(context.Background(), client.ListBlocksArgs{Instance: "my-rpaas"})
+						assert.NoError(t, err)
+						assert.Len(t, blocks, 1)
+						return nil
+					},
+				),
+			},
+			{
+				// changing the default replaces the block
+				Config:             testAccRpaasBlockConfigWithProviderDefaults("rpaasv2-be", "other-rpaas", ""),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				// explicit values on the resource take precedence
+				Config:   testAccRpaasBlockConfigWithProviderDefaults("rpaasv2-be", "other-rpaas", "my-rpaas"),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func testAccRpaasBlockConfigWithProviderDefaults(serviceName, defaultInstance, instance string) string {
+	instanceArgument := ""
+	if instance != "" {
+		instanceArgument = fmt.Sprintf("instance = %q", instance)
+	}
+
+	return fmt.Sprintf(`
+provider "rpaas" {
+	default_service_name = %q
+	default_instance = %q
+}
+
+resource "rpaas_block" "custom_block_server" {
+	%s
+
+	name = "http"
+	content = "# nginx config"
+}
+`, serviceName, defaultInstance, instanceArgument)
+}
+
 func testAccRpaasBlockConfig(block, content string) string {
 	return fmt.Sprintf(`
 resource "rpaas_block" "custom_block_server" {
@@ -223,3 +393,29 @@ resource "rpaas_block" "custom_block_server" {
 }
 `, serverName, block, content)
 }
+
+func testAccRpaasBlockConfigWithTemplate(upstream string) string {
+	return fmt.Sprintf(`
+resource "rpaas_block" "templated" {
+	instance     = "my-rpaas"
+	service_name = "rpaasv2-be"
+
+	name = "server"
+
+	template = <<-EOF
+	location /app {
+	{{ template "headers" . }}  proxy_pass http://{{ .upstream }};
+	}
+	EOF
+
+	includes = {
+		headers = "  proxy_set_header X-Env {{ .env }};\n"
+	}
+
+	vars = {
+		env      = "prod"
+		upstream = %q
+	}
+}
+`, upstream)
+}
@@ -14,31 +14,33 @@ import (
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	rpaas_client "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
 	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
 )
 
 func resourceRpaasCertManager() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasCertManagerCreate,
-		ReadContext:   resourceRpaasCertManagerRead,
-		UpdateContext: resourceRpaasCertManagerUpdate,
-		DeleteContext: resourceRpaasCertManagerDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasCertManagerCreate,
+		ReadContext:    resourceRpaasCertManagerRead,
+		UpdateContext:  resourceRpaasCertManagerUpdate,
+		DeleteContext:  resourceRpaasCertManagerDelete,
+		CustomizeDiff:  resolveServiceInstanceDefaults,
+		Importer:       resourceRpaasCertManagerImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasCertManagerV0Type(), resourceStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"issuer": {
 				Type:        schema.TypeString,
@@ -69,11 +71,6 @@ func resourceRpaasCertManagerCreate(ctx context.Context, d *schema.ResourceData,
 	provider := meta.(*rpaasProvider)
 
 	serviceName := d.Get("service_name").(string)
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	instance := d.Get("instance").(string)
 	issuer := d.Get("issuer").(string)
 	certificateName := d.Get("certificate_name").(string)
@@ -88,30 +85,21 @@ func resourceRpaasCertManagerCreate(ctx context.Context, d *schema.ResourceData,
 		"dnsNames":         dnsNames,
 	})
 
-	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
+	err := rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
 		// UpdateCertManager is really an upsert
-		return nil, rpaasClient.UpdateCertManager(ctx, rpaas_client.UpdateCertManagerArgs{
-			Instance: instance,
-			CertManager: types.CertManager{
-				Name:     certificateName,
-				Issuer:   issuer,
-				DNSNames: dnsNames,
-			},
+		return provider.Client(serviceName, instance).UpdateCertManager(ctx, types.CertManager{
+			Name:     certificateName,
+			Issuer:   issuer,
+			DNSNames: dnsNames,
 		})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("could not create Cert Manager request: %v", err)
 	}
 
-	var id string
-	if certificateName == "" {
-		id = fmt.Sprintf("%s::%s::%s", serviceName, instance, issuer)
-	} else {
-		id = fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, issuer, certificateName)
-	}
-
-	d.SetId(id)
+	d.SetId(buildCertManagerID(serviceName, instance, issuer, certificateName))
 	return resourceRpaasCertManagerRead(ctx, d, meta)
 }
 
@@ -122,35 +110,34 @@ func resourceRpaasCertManagerRead(ctx context.Context, d *schema.ResourceData, m
 	if err != nil {
 		return diag.Errorf("Unable to parse CertManager ID: %v", err)
 	}
-	var id string
-	if certificateName == "" {
-		id = fmt.Sprintf("%s::%s::%s", serviceName, instance, issuer)
-	} else {
-		id = fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, issuer, certificateName)
-	}
-	d.SetId(id)
+	d.SetId(buildCertManagerID(serviceName, instance, issuer, certificateName))
 	d.Set("service_name", serviceName)
 	d.Set("instance", instance)
 	d.Set("issuer", issuer)
 	d.Set("certificate_name", certificateName)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	var requests []types.CertManager
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
-		r, nerr := rpaasClient.ListCertManagerRequests(ctx, instance)
+		r, response, nerr := cachedRead(ctx, provider.cache, serviceName, instance, "cert_manager_requests", func(ctx context.Context) ([]types.CertManager, *http.Response, error) {
+			return provider.Client(serviceName, instance).ListCertManagerRequests(ctx)
+		})
 		if nerr != nil {
-			return nil, nerr
+			return response, nerr
 		}
 
 		requests = r
-		return nil, nil
+		return response, nil
 	})
 
+	if isNotFoundError(err) {
+		tflog.Debug(ctx, "Removing rpaas_cert_manager from state as its instance is not found on RPaaS", map[string]interface{}{
+			"id": d.Id(),
+		})
+		d.SetId("")
+		return nil
+	}
+
 	if err != nil {
 		return diag.Errorf("could not list Cert Manager requests: %v", err)
 	}
@@ -174,11 +161,6 @@ func resourceRpaasCertManagerUpdate(ctx context.Context, d *schema.ResourceData,
 	if err != nil {
 		return diag.Errorf("Unable to parse CertManager ID: %v", err)
 	}
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	dnsNames := asSliceOfStrings(d.Get("dns_names"))
 
 	tflog.Info(ctx, "Update rpaas_cert_manager", map[string]interface{}{
@@ -190,15 +172,13 @@ func resourceRpaasCertManagerUpdate(ctx context.Context, d *schema.ResourceData,
 	})
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateCertManager(ctx, rpaas_client.UpdateCertManagerArgs{
-			Instance: instance,
-			CertManager: types.CertManager{
-				Name:     certificateName,
-				Issuer:   issuer,
-				DNSNames: dnsNames,
-			},
+		return provider.Client(serviceName, instance).UpdateCertManager(ctx, types.CertManager{
+			Name:     certificateName,
+			Issuer:   issuer,
+			DNSNames: dnsNames,
 		})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 	if err != nil {
 		return diag.Errorf("could not update Cert Manager request: %v", err)
 	}
@@ -214,11 +194,6 @@ func resourceRpaasCertManagerDelete(ctx context.Context, d *schema.ResourceData,
 
 	provider := meta.(*rpaasProvider)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete rpaas_cert_manager", map[string]interface{}{
 		"certificate_name": certificateName,
 		"service":          serviceName,
@@ -230,10 +205,11 @@ func resourceRpaasCertManagerDelete(ctx context.Context, d *schema.ResourceData,
 		log.Printf("[DEBUG] Removing Cert Manager certificate request: {Service: %s, Instance: %s, Issuer: %s}", serviceName, instance, issuer)
 
 		if certificateName != "" {
-			return nil, rpaasClient.DeleteCertManagerByName(ctx, instance, certificateName)
+			return provider.Client(serviceName, instance).DeleteCertManagerByName(ctx, certificateName)
 		}
-		return nil, rpaasClient.DeleteCertManagerByIssuer(ctx, instance, issuer)
+		return provider.Client(serviceName, instance).DeleteCertManagerByIssuer(ctx, issuer)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("cannot remove Cert Manager request: %v", err)
@@ -264,10 +240,18 @@ func asSliceOfStrings(data interface{}) []string {
 	return values
 }
 
+func buildCertManagerID(serviceName, instance, issuer, name string) string {
+	if name == "" {
+		return buildID(serviceName, instance, issuer)
+	}
+
+	return buildID(serviceName, instance, issuer, name)
+}
+
 func parseCertManagerID(id string) (serviceName, instance, issuer, name string, err error) {
-	splitID := strings.Split(id, "::")
+	parts := splitID(id)
 
-	if len(splitID) > 4 || len(splitID) < 3 {
+	if len(parts) > 4 || len(parts) < 3 {
 		serviceName, instance, issuer, err = parseCertManagerID_legacyV0(id)
 		if err != nil {
 			err = fmt.Errorf("Could not parse id %q. Format should be \"service::instance::issuer::certificateName\"", id)
@@ -275,11 +259,11 @@ func parseCertManagerID(id string) (serviceName, instance, issuer, name string,
 		return
 	}
 
-	serviceName = splitID[0]
-	instance = splitID[1]
-	issuer = splitID[2]
-	if len(splitID) == 4 {
-		name = splitID[3] // blank on older versions
+	serviceName = parts[0]
+	instance = parts[1]
+	issuer = parts[2]
+	if len(parts) == 4 {
+		name = parts[3] // blank on older versions
 	}
 	return
 }
@@ -296,3 +280,48 @@ func parseCertManagerID_legacyV0(id string) (serviceName, instance, issuer strin
 	issuer = splitID[2]
 	return
 }
+
+var rpaasCertManagerID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "issuer"},
+	Optional: []string{"certificate_name"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, issuer, certificateName, err := parseCertManagerID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := map[string]string{"service_name": serviceName, "instance": instance, "issuer": issuer}
+		if certificateName != "" { // blank on older versions
+			attributes["certificate_name"] = certificateName
+		}
+		return attributes, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildCertManagerID(a["service_name"], a["instance"], a["issuer"], a["certificate_name"])
+	},
+}
+
+func resourceRpaasCertManagerImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_cert_manager",
+		Keys:     []string{"issuer", "certificate_name"},
+		ParseID:  rpaasCertManagerID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			requests, _, err := provider.Client(serviceName, instance).ListCertManagerRequests(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, r := range requests {
+				candidates = append(candidates, importCandidate{
+					ID:         buildCertManagerID(serviceName, instance, r.Issuer, r.Name),
+					Attributes: map[string]string{"issuer": r.Issuer, "certificate_name": r.Name},
+				})
+			}
+			return candidates, nil
+		},
+	}
+
+	return importer.Importer()
+}
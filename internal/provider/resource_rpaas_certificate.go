@@ -13,31 +13,33 @@ import (
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	rpaas_client "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
-	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/autogenerated"
 )
 
 func resourceRpaasCertificate() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasCertificateCreate,
-		ReadContext:   resourceRpaasCertificateRead,
-		UpdateContext: resourceRpaasCertificateUpdate,
-		DeleteContext: resourceRpaasCertificateDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasCertificateCreate,
+		ReadContext:    resourceRpaasCertificateRead,
+		UpdateContext:  resourceRpaasCertificateUpdate,
+		DeleteContext:  resourceRpaasCertificateDelete,
+		CustomizeDiff:  resolveServiceInstanceDefaults,
+		Importer:       resourceRpaasCertificateImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasCertificateV0Type(), resourceStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"name": {
 				Type:        schema.TypeString,
@@ -56,6 +58,7 @@ func resourceRpaasCertificate() *schema.Resource {
 				Sensitive:   true,
 				Description: "Key content",
 			},
+			"wait_for_rollout": waitForRolloutSchema(),
 		},
 	}
 }
@@ -67,17 +70,8 @@ func resourceRpaasCertificateCreate(ctx context.Context, d *schema.ResourceData,
 	serviceName := d.Get("service_name").(string)
 	certName := d.Get("name").(string)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
-	args := rpaas_client.UpdateCertificateArgs{
-		Instance:    instance,
-		Name:        certName,
-		Certificate: d.Get("certificate").(string),
-		Key:         d.Get("key").(string),
-	}
+	certificate := d.Get("certificate").(string)
+	key := d.Get("key").(string)
 
 	tflog.Info(ctx, "Create rpaas_certificate", map[string]interface{}{
 		"service":  serviceName,
@@ -85,16 +79,28 @@ func resourceRpaasCertificateCreate(ctx context.Context, d *schema.ResourceData,
 		"name":     certName,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateCertificate(ctx, args) // UpdateCertificate is really an upsert
+		return provider.Client(serviceName, instance).UpdateCertificate(ctx, certName, certificate, key) // UpdateCertificate is really an upsert
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to create certificate %s for instance %s: %v", certName, instance, err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, certName))
-	return resourceRpaasCertificateRead(ctx, d, meta)
+	d.SetId(buildID(serviceName, instance, certName))
+
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutCreate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasCertificateRead(ctx, d, meta)...)
 }
 
 func resourceRpaasCertificateRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -105,34 +111,36 @@ func resourceRpaasCertificateRead(ctx context.Context, d *schema.ResourceData, m
 		return diag.Errorf("Unable to parse Certificate ID: %v", err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, certName))
+	d.SetId(buildID(serviceName, instance, certName))
 	d.Set("service_name", serviceName)
 	d.Set("instance", instance)
 	d.Set("name", certName)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
-	var info *types.InstanceInfo
+	var info *autogenerated.InstanceInfo
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
-		i, nerr := rpaasClient.Info(ctx, rpaas_client.InfoArgs{Instance: instance})
+		i, response, nerr := cachedRead(ctx, provider.cache, serviceName, instance, "info", func(ctx context.Context) (*autogenerated.InstanceInfo, *http.Response, error) {
+			return provider.Client(serviceName, instance).Info(ctx)
+		})
 		if nerr != nil {
-			return nil, nerr
+			return response, nerr
 		}
 
 		info = i
-		return nil, nil
+		return response, nil
 	})
 
+	if isNotFoundError(err) {
+		d.SetId("")
+		return nil
+	}
+
 	if err != nil {
 		return diag.Errorf("Unable to read rpaas instance %s: %v", instance, err)
 	}
 
-	for _, certificate := range info.Certificates {
-		if certificate.Name == certName {
+	for _, certificate := range info.GetCertificates() {
+		if certificate.GetName() == certName {
 			return nil
 		}
 	}
@@ -149,17 +157,8 @@ func resourceRpaasCertificateUpdate(ctx context.Context, d *schema.ResourceData,
 		return diag.Errorf("Unable to parse Certificate ID: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
-	args := rpaas_client.UpdateCertificateArgs{
-		Instance:    instance,
-		Name:        certName,
-		Certificate: d.Get("certificate").(string),
-		Key:         d.Get("key").(string),
-	}
+	certificate := d.Get("certificate").(string)
+	key := d.Get("key").(string)
 
 	tflog.Info(ctx, "Update rpaas_certificate", map[string]interface{}{
 		"service":  serviceName,
@@ -167,15 +166,26 @@ func resourceRpaasCertificateUpdate(ctx context.Context, d *schema.ResourceData,
 		"name":     certName,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateCertificate(ctx, args)
+		return provider.Client(serviceName, instance).UpdateCertificate(ctx, certName, certificate, key)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to update certificate %s for instance %s: %v", certName, instance, err)
 	}
 
-	return resourceRpaasCertificateRead(ctx, d, meta)
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutUpdate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasCertificateRead(ctx, d, meta)...)
 }
 
 func resourceRpaasCertificateDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -184,34 +194,32 @@ func resourceRpaasCertificateDelete(ctx context.Context, d *schema.ResourceData,
 	instance := d.Get("instance").(string)
 	serviceName := d.Get("service_name").(string)
 	certName := d.Get("name").(string)
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete rpaas_certificate", map[string]interface{}{
 		"service":  serviceName,
 		"instance": instance,
 		"name":     certName,
 	})
 
-	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, rpaasClient.DeleteCertificate(ctx, rpaas_client.DeleteCertificateArgs{
-			Instance: instance,
-			Name:     certName,
-		})
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
+		return provider.Client(serviceName, instance).DeleteCertificate(ctx, certName)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to remove certificate %s for instance %s: %v", certName, instance, err)
 	}
-	return nil
+	return waiter.Wait(ctx, d.Timeout(schema.TimeoutDelete))
 }
 
 func parseRpaasCertificateID(id string) (serviceName, instance, certName string, err error) {
-	splitID := strings.Split(id, "::")
+	parts := splitID(id)
 
-	if len(splitID) != 3 {
+	if len(parts) != 3 {
 		serviceName, instance, certName, err = parseRpaasCertificateID_legacyV0(id)
 		if err != nil {
 			err = fmt.Errorf("Could not parse id %q. Format should be \"service::instance::certName\"", id)
@@ -219,9 +227,9 @@ func parseRpaasCertificateID(id string) (serviceName, instance, certName string,
 		return
 	}
 
-	serviceName = splitID[0]
-	instance = splitID[1]
-	certName = splitID[2]
+	serviceName = parts[0]
+	instance = parts[1]
+	certName = parts[2]
 	return
 }
 
@@ -238,3 +246,43 @@ func parseRpaasCertificateID_legacyV0(id string) (serviceName, instance, certNam
 	certName = splitID[2]
 	return
 }
+
+var rpaasCertificateID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "name"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, certName, err := parseRpaasCertificateID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"service_name": serviceName, "instance": instance, "name": certName}, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildID(a["service_name"], a["instance"], a["name"])
+	},
+}
+
+func resourceRpaasCertificateImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_certificate",
+		Keys:     []string{"name"},
+		ParseID:  rpaasCertificateID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			info, _, err := provider.Client(serviceName, instance).Info(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, c := range info.GetCertificates() {
+				candidates = append(candidates, importCandidate{
+					ID:         buildID(serviceName, instance, c.GetName()),
+					Attributes: map[string]string{"name": c.GetName()},
+				})
+			}
+			return candidates, nil
+		},
+	}
+
+	return importer.Importer()
+}
@@ -14,38 +14,41 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	rpaas_client "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
 	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
 )
 
 func resourceRpaasFile() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasFileCreate,
-		ReadContext:   resourceRpaasFileRead,
-		UpdateContext: resourceRpaasFileUpdate,
-		DeleteContext: resourceRpaasFileDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasFileCreate,
+		ReadContext:    resourceRpaasFileRead,
+		UpdateContext:  resourceRpaasFileUpdate,
+		DeleteContext:  resourceRpaasFileDelete,
+		CustomizeDiff:  customdiff.Sequence(resolveServiceInstanceDefaults, resourceRpaasFileCustomizeDiff),
+		Importer:       resourceRpaasFileImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasFileV0Type(), resourceStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"name": {
 				Type:        schema.TypeString,
 				Required:    true,
 				ForceNew:    true,
-				Description: "Name of a persistent file in the instance filesystem",
+				Description: "Name of a persistent file in the instance filesystem. Files ending with `.lua` have their Lua syntax validated at plan time.",
 			},
 			"content": {
 				Type:         schema.TypeString,
@@ -59,6 +62,7 @@ func resourceRpaasFile() *schema.Resource {
 				ExactlyOneOf: []string{"content", "content_base64"},
 				Description:  "Content of the persistent file in the instance filesystem, expected to be binary encoded as base64 string. (v0.2.3)",
 			},
+			"wait_for_rollout": waitForRolloutSchema(),
 		},
 	}
 }
@@ -74,32 +78,34 @@ func resourceRpaasFileCreate(ctx context.Context, d *schema.ResourceData, meta i
 		return diag.Errorf("Unable to read content: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Create file", map[string]interface{}{
 		"service":  serviceName,
 		"instance": instance,
 		"name":     filename,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, rpaasClient.AddExtraFiles(ctx, rpaas_client.ExtraFilesArgs{
-			Instance: instance,
-			Files: []types.RpaasFile{
-				{Name: filename, Content: []byte(content)},
-			},
-		})
+		return provider.Client(serviceName, instance).AddExtraFile(ctx, types.RpaasFile{Name: filename, Content: []byte(content)})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to create file %q for instance %s: %v", filename, instance, err)
 	}
 
-	d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, filename))
-	return resourceRpaasFileRead(ctx, d, meta)
+	d.SetId(buildID(serviceName, instance, filename))
+
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutCreate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasFileRead(ctx, d, meta)...)
 }
 
 func resourceRpaasFileUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -115,11 +121,6 @@ func resourceRpaasFileUpdate(ctx context.Context, d *schema.ResourceData, meta i
 		return diag.Errorf("Unable to read content: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Update file", map[string]interface{}{
 		"id":       d.Id(),
 		"service":  serviceName,
@@ -127,20 +128,26 @@ func resourceRpaasFileUpdate(ctx context.Context, d *schema.ResourceData, meta i
 		"name":     filename,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
-		return nil, rpaasClient.UpdateExtraFiles(ctx, rpaas_client.ExtraFilesArgs{
-			Instance: instance,
-			Files: []types.RpaasFile{
-				{Name: filename, Content: []byte(content)},
-			},
-		})
+		return provider.Client(serviceName, instance).UpdateExtraFile(ctx, types.RpaasFile{Name: filename, Content: []byte(content)})
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to update file %q for instance %s: %v", filename, instance, err)
 	}
 
-	return resourceRpaasFileRead(ctx, d, meta)
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutUpdate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasFileRead(ctx, d, meta)...)
 }
 
 func resourceRpaasFileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -150,29 +157,21 @@ func resourceRpaasFileRead(ctx context.Context, d *schema.ResourceData, meta int
 	if err != nil {
 		return diag.Errorf("Unable to parse File ID: %v", err)
 	}
-	d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, filename))
-
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
+	d.SetId(buildID(serviceName, instance, filename))
 
 	var rpaasFile types.RpaasFile
 
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
-		f, nerr := rpaasClient.GetExtraFile(ctx, rpaas_client.GetExtraFileArgs{
-			Instance: instance,
-			FileName: filename,
-		})
+		f, response, nerr := provider.Client(serviceName, instance).GetExtraFile(ctx, filename)
 		if nerr != nil {
-			return nil, err
+			return response, nerr
 		}
 
 		rpaasFile = f
-		return nil, nil
+		return response, nil
 	})
 
-	if rpaas_client.IsNotFoundError(err) {
+	if isNotFoundError(err) {
 		d.SetId("")
 		return nil
 	}
@@ -194,11 +193,6 @@ func resourceRpaasFileDelete(ctx context.Context, d *schema.ResourceData, meta i
 	serviceName := d.Get("service_name").(string)
 	filename := d.Get("name").(string)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete file", map[string]interface{}{
 		"id":       d.Id(),
 		"service":  serviceName,
@@ -206,19 +200,40 @@ func resourceRpaasFileDelete(ctx context.Context, d *schema.ResourceData, meta i
 		"name":     filename,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
-		return nil, rpaasClient.DeleteExtraFiles(ctx,
-			rpaas_client.DeleteExtraFilesArgs{
-				Instance: instance,
-				Files:    []string{filename},
-			},
-		)
+		return provider.Client(serviceName, instance).DeleteExtraFile(ctx, filename)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to remove file %q for instance %s: %v", filename, instance, err)
 	}
 
+	return waiter.Wait(ctx, d.Timeout(schema.TimeoutDelete))
+}
+
+func resourceRpaasFileCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if !isLuaFile(d.Get("name").(string)) {
+		return nil
+	}
+
+	if v, ok := d.GetOk("content"); ok && d.NewValueKnown("content") {
+		return validateLuaSyntax("content", v.(string))
+	}
+
+	if v, ok := d.GetOk("content_base64"); ok && d.NewValueKnown("content_base64") {
+		content, err := base64.StdEncoding.DecodeString(v.(string))
+		if err != nil {
+			return fmt.Errorf("Unable to decode \"content_base64\": %v", err)
+		}
+		return validateLuaSyntax("content_base64", string(content))
+	}
+
 	return nil
 }
 
@@ -244,17 +259,18 @@ func setResourceRpaasFileContent(d *schema.ResourceData, content []byte) {
 }
 
 func parseRpaasFileID(id string) (serviceName, instance, filename string, err error) {
-	splitID := strings.Split(id, "::")
-	if len(splitID) != 3 {
+	parts := splitID(id)
+
+	if len(parts) != 3 {
 		serviceName, instance, filename, err = parseRpaasFileID_legacyV0(id)
 		if err != nil {
 			err = fmt.Errorf("Could not parse id %q. Format should be \"service::instance::file\"", id)
 		}
 		return
 	}
-	serviceName = splitID[0]
-	instance = splitID[1]
-	filename = splitID[2]
+	serviceName = parts[0]
+	instance = parts[1]
+	filename = parts[2]
 	return
 }
 
@@ -269,3 +285,43 @@ func parseRpaasFileID_legacyV0(id string) (serviceName, instance, filename strin
 	filename = splitID[2]
 	return
 }
+
+var rpaasFileID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "name"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, filename, err := parseRpaasFileID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"service_name": serviceName, "instance": instance, "name": filename}, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildID(a["service_name"], a["instance"], a["name"])
+	},
+}
+
+func resourceRpaasFileImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_file",
+		Keys:     []string{"name"},
+		ParseID:  rpaasFileID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			files, _, err := provider.Client(serviceName, instance).ListExtraFiles(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, f := range files {
+				candidates = append(candidates, importCandidate{
+					ID:         buildID(serviceName, instance, f.Name),
+					Attributes: map[string]string{"name": f.Name},
+				})
+			}
+			return candidates, nil
+		},
+	}
+
+	return importer.Importer()
+}
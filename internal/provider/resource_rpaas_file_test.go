@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
@@ -179,6 +180,30 @@ func TestAccRpaasFile_import(t *testing.T) {
 	})
 }
 
+func TestAccRpaasFile_luaSyntax(t *testing.T) {
+	_, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccRpaasFileConfig("script.lua", "if true then"),
+				ExpectError: regexp.MustCompile(`Invalid Lua code in "content"`),
+			},
+			{
+				Config:      testAccRpaasFileConfigExtraParam("script.lua", fmt.Sprintf("content_base64 = %q", base64.StdEncoding.EncodeToString([]byte("return {")))),
+				ExpectError: regexp.MustCompile(`Invalid Lua code in "content_base64"`),
+			},
+			{
+				Config: testAccRpaasFileConfig("not-lua.txt", "if true then"),
+				Check:  testAccResourceExists("rpaas_file.custom_file"),
+			},
+		},
+	})
+}
+
 func setupRpaasFilesWithClient(t *testing.T, testAPIClient client.Client) {
 	if err := testAPIClient.AddExtraFiles(context.Background(),
 		client.ExtraFilesArgs{
@@ -0,0 +1,387 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+const (
+	metadataModeAdditive      = "additive"
+	metadataModeAuthoritative = "authoritative"
+
+	// reservedMetadataPrefix prefixes the keys set by the operator, which
+	// are neither returned nor accepted by the metadata API.
+	reservedMetadataPrefix = "rpaas.extensions.tsuru.io"
+)
+
+var (
+	metadataModes        = []string{metadataModeAdditive, metadataModeAuthoritative}
+	reservedMetadataKeys = []string{"rpaas_instance", "rpaas_service"}
+)
+
+func resourceRpaasMetadata() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceRpaasMetadataCreate,
+		ReadContext:   resourceRpaasMetadataRead,
+		UpdateContext: resourceRpaasMetadataUpdate,
+		DeleteContext: resourceRpaasMetadataDelete,
+		CustomizeDiff: resolveServiceInstanceDefaults,
+		Importer:      resourceRpaasMetadataImporter(),
+		Description:   "Labels and annotations of an RPaaS instance. Keys prefixed with `rpaas.extensions.tsuru.io`, as well as `rpaas_instance` and `rpaas_service`, are reserved to RPaaS and cannot be managed. There should be a single `rpaas_metadata` per instance.",
+		Schema: map[string]*schema.Schema{
+			"instance": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
+			},
+			"service_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
+			},
+			"labels": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: validateMetadataKeys,
+				Description:      "Labels of the instance, propagated to its Kubernetes objects.",
+			},
+			"annotations": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: validateMetadataKeys,
+				Description:      "Annotations of the instance, propagated to its Kubernetes objects.",
+			},
+			"mode": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  metadataModeAdditive,
+				ValidateDiagFunc: func(value interface{}, path cty.Path) diag.Diagnostics {
+					v := value.(string)
+					if !containsString(metadataModes, v) {
+						return diag.Errorf("Unexpected mode %q. Allowed values: %v", v, metadataModes)
+					}
+					return nil
+				},
+				Description: fmt.Sprintf("How the labels and annotations are managed. With `%s`, only the ones configured are managed, and the other ones of the instance are kept. With `%s`, the ones not configured are removed from the instance. Allowed values: %v", metadataModeAdditive, metadataModeAuthoritative, metadataModes),
+			},
+		},
+	}
+}
+
+func resourceRpaasMetadataCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	provider := meta.(*rpaasProvider)
+
+	serviceName := d.Get("service_name").(string)
+	instance := d.Get("instance").(string)
+
+	tflog.Info(ctx, "Create metadata", map[string]interface{}{
+		"service":  serviceName,
+		"instance": instance,
+		"mode":     d.Get("mode"),
+	})
+
+	if err := applyRpaasMetadata(ctx, d, provider, serviceName, instance, d.Timeout(schema.TimeoutCreate)); err != nil {
+		return diag.Errorf("Unable to set metadata of instance %s: %v", instance, err)
+	}
+
+	d.SetId(buildID(serviceName, instance))
+	return resourceRpaasMetadataRead(ctx, d, meta)
+}
+
+func resourceRpaasMetadataRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	provider := meta.(*rpaasProvider)
+
+	serviceName, instance, err := parseRpaasInstanceID(d.Id())
+	if err != nil {
+		return diag.Errorf("Unable to parse metadata ID: %v", err)
+	}
+	d.SetId(buildID(serviceName, instance))
+
+	metadata, err := getRpaasMetadata(ctx, provider, serviceName, instance, d.Timeout(schema.TimeoutRead))
+	if isNotFoundError(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("Unable to get metadata of instance %s: %v", instance, err)
+	}
+
+	mode := d.Get("mode").(string)
+	if mode == "" {
+		mode = metadataModeAdditive
+	}
+
+	labels := metadataMap(metadata.Labels)
+	annotations := metadataMap(metadata.Annotations)
+	if mode == metadataModeAdditive {
+		// only the keys managed by this resource are compared, so that the
+		// other ones do not show up as drift
+		labels = filterMetadataKeys(labels, d.Get("labels").(map[string]interface{}))
+		annotations = filterMetadataKeys(annotations, d.Get("annotations").(map[string]interface{}))
+	}
+
+	d.Set("service_name", serviceName)
+	d.Set("instance", instance)
+	d.Set("mode", mode)
+	d.Set("labels", labels)
+	d.Set("annotations", annotations)
+	return nil
+}
+
+func resourceRpaasMetadataUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	provider := meta.(*rpaasProvider)
+
+	serviceName, instance, err := parseRpaasInstanceID(d.Id())
+	if err != nil {
+		return diag.Errorf("Unable to parse metadata ID: %v", err)
+	}
+
+	tflog.Info(ctx, "Update metadata", map[string]interface{}{
+		"id":       d.Id(),
+		"service":  serviceName,
+		"instance": instance,
+		"mode":     d.Get("mode"),
+	})
+
+	if err = applyRpaasMetadata(ctx, d, provider, serviceName, instance, d.Timeout(schema.TimeoutUpdate)); err != nil {
+		return diag.Errorf("Unable to update metadata of instance %s: %v", instance, err)
+	}
+
+	return resourceRpaasMetadataRead(ctx, d, meta)
+}
+
+func resourceRpaasMetadataDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	provider := meta.(*rpaasProvider)
+
+	serviceName, instance, err := parseRpaasInstanceID(d.Id())
+	if err != nil {
+		return diag.Errorf("Unable to parse metadata ID: %v", err)
+	}
+
+	tflog.Info(ctx, "Delete metadata", map[string]interface{}{
+		"id":       d.Id(),
+		"service":  serviceName,
+		"instance": instance,
+	})
+
+	current, err := getRpaasMetadata(ctx, provider, serviceName, instance, d.Timeout(schema.TimeoutDelete))
+	if isNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("Unable to get metadata of instance %s: %v", instance, err)
+	}
+
+	// the labels and annotations in the state are the managed ones, on both
+	// modes
+	unset := types.Metadata{
+		Labels:      unsetMetadataItems(current.Labels, d.Get("labels").(map[string]interface{}), nil),
+		Annotations: unsetMetadataItems(current.Annotations, d.Get("annotations").(map[string]interface{}), nil),
+	}
+	if len(unset.Labels) == 0 && len(unset.Annotations) == 0 {
+		return nil
+	}
+
+	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
+		return provider.Client(serviceName, instance).UnsetMetadata(ctx, unset)
+	})
+	provider.cache.Invalidate(serviceName, instance)
+
+	if err != nil && !isNotFoundError(err) {
+		return diag.Errorf("Unable to remove metadata of instance %s: %v", instance, err)
+	}
+
+	return nil
+}
+
+// applyRpaasMetadata sets the configured labels and annotations, then
+// removes the ones no longer managed: on additive mode, the ones removed
+// from the configuration, and on authoritative mode, any other one.
+func applyRpaasMetadata(ctx context.Context, d *schema.ResourceData, provider *rpaasProvider, serviceName, instance string, timeout time.Duration) error {
+	labels := d.Get("labels").(map[string]interface{})
+	annotations := d.Get("annotations").(map[string]interface{})
+
+	// the API fails to unset missing keys, so only the existing ones are
+	// removed
+	current, err := getRpaasMetadata(ctx, provider, serviceName, instance, timeout)
+	if err != nil {
+		return err
+	}
+
+	managedLabels, managedAnnotations := metadataMap(current.Labels), metadataMap(current.Annotations)
+	if d.Get("mode").(string) != metadataModeAuthoritative {
+		o, _ := d.GetChange("labels")
+		managedLabels = o.(map[string]interface{})
+		o, _ = d.GetChange("annotations")
+		managedAnnotations = o.(map[string]interface{})
+	}
+
+	set := types.Metadata{
+		Labels:      metadataItems(labels),
+		Annotations: metadataItems(annotations),
+	}
+	unset := types.Metadata{
+		Labels:      unsetMetadataItems(current.Labels, managedLabels, labels),
+		Annotations: unsetMetadataItems(current.Annotations, managedAnnotations, annotations),
+	}
+
+	client := provider.Client(serviceName, instance)
+	defer provider.cache.Invalidate(serviceName, instance)
+
+	if len(set.Labels) > 0 || len(set.Annotations) > 0 {
+		err = rpaasRetry(ctx, timeout, func() (*http.Response, error) {
+			return client.SetMetadata(ctx, set)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(unset.Labels) > 0 || len(unset.Annotations) > 0 {
+		err = rpaasRetry(ctx, timeout, func() (*http.Response, error) {
+			return client.UnsetMetadata(ctx, unset)
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to remove labels %v and annotations %v: %w", metadataNames(unset.Labels), metadataNames(unset.Annotations), err)
+		}
+	}
+
+	return nil
+}
+
+func getRpaasMetadata(ctx context.Context, provider *rpaasProvider, serviceName, instance string, timeout time.Duration) (types.Metadata, error) {
+	var metadata types.Metadata
+	err := rpaasRetry(ctx, timeout, func() (*http.Response, error) {
+		m, response, nerr := provider.Client(serviceName, instance).GetMetadata(ctx)
+		if nerr != nil {
+			return response, nerr
+		}
+
+		metadata = m
+		return response, nil
+	})
+
+	return metadata, err
+}
+
+// unsetMetadataItems returns the current items to remove: the managed ones
+// not kept.
+func unsetMetadataItems(current []types.MetadataItem, managed, keep map[string]interface{}) []types.MetadataItem {
+	var items []types.MetadataItem
+	for _, item := range current {
+		_, isManaged := managed[item.Name]
+		_, isKept := keep[item.Name]
+		if isManaged && !isKept {
+			items = append(items, types.MetadataItem{Name: item.Name})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+func metadataItems(m map[string]interface{}) []types.MetadataItem {
+	items := make([]types.MetadataItem, 0, len(m))
+	for k, v := range m {
+		items = append(items, types.MetadataItem{Name: k, Value: v.(string)})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+func metadataMap(items []types.MetadataItem) map[string]interface{} {
+	m := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+func metadataNames(items []types.MetadataItem) []string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func filterMetadataKeys(m, keys map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{})
+	for k := range keys {
+		if v, found := m[k]; found {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func validateMetadataKeys(value interface{}, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for k := range value.(map[string]interface{}) {
+		if strings.HasPrefix(k, reservedMetadataPrefix) || containsString(reservedMetadataKeys, k) {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       fmt.Sprintf("Reserved key %q", k),
+				Detail:        fmt.Sprintf("Keys prefixed with %q and %v are managed by RPaaS.", reservedMetadataPrefix, reservedMetadataKeys),
+				AttributePath: path.IndexString(k),
+			})
+		}
+	}
+	return diags
+}
+
+var rpaasMetadataID = resourceIDFormat{
+	Required: []string{"service_name", "instance"},
+	Parse: func(id string) (map[string]string, error) {
+		service, instance, err := parseRpaasInstanceID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"service_name": service, "instance": instance}, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildID(a["service_name"], a["instance"])
+	},
+}
+
+func resourceRpaasMetadataImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_metadata",
+		ParseID:  rpaasMetadataID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			_, _, err := provider.Client(serviceName, instance).GetMetadata(ctx)
+			if isNotFoundError(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return []importCandidate{{ID: buildID(serviceName, instance)}}, nil
+		},
+	}
+
+	return importer.Importer()
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+func TestUnsetMetadataItems(t *testing.T) {
+	current := []types.MetadataItem{{Name: "team", Value: "platform"}, {Name: "cost-center", Value: "1234"}, {Name: "owner", Value: "me"}}
+
+	// additive: only the managed ones no longer configured
+	items := unsetMetadataItems(current, map[string]interface{}{"team": "platform", "cost-center": "1234", "gone": "x"}, map[string]interface{}{"team": "platform"})
+	assert.Equal(t, []types.MetadataItem{{Name: "cost-center"}}, items)
+
+	// authoritative: every one not configured
+	items = unsetMetadataItems(current, metadataMap(current), map[string]interface{}{"team": "platform"})
+	assert.Equal(t, []types.MetadataItem{{Name: "cost-center"}, {Name: "owner"}}, items)
+
+	assert.Empty(t, unsetMetadataItems(current, map[string]interface{}{}, nil))
+}
+
+func TestValidateMetadataKeys(t *testing.T) {
+	assert.Empty(t, validateMetadataKeys(map[string]interface{}{"team": "platform", "example.com/owner": "me"}, nil))
+
+	diags := validateMetadataKeys(map[string]interface{}{"rpaas.extensions.tsuru.io/plan-name": "x"}, nil)
+	require.Len(t, diags, 1)
+	assert.Equal(t, `Reserved key "rpaas.extensions.tsuru.io/plan-name"`, diags[0].Summary)
+
+	assert.Len(t, validateMetadataKeys(map[string]interface{}{"rpaas_instance": "x", "rpaas_service": "y"}, nil), 2)
+}
+
+func TestResourceRpaasMetadata(t *testing.T) {
+	server, provider := setupTestRpaasServer(t)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client := provider.Client("rpaasv2", "my-rpaas")
+	_, err := client.SetMetadata(ctx, types.Metadata{Labels: []types.MetadataItem{{Name: "owner", Value: "someone-else"}}})
+	require.NoError(t, err)
+
+	labels := func() []types.MetadataItem {
+		metadata, _, err := client.GetMetadata(ctx)
+		require.NoError(t, err)
+		return metadata.Labels
+	}
+
+	r := resourceRpaasMetadata()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"service_name": "rpaasv2",
+		"instance":     "my-rpaas",
+		"labels":       map[string]interface{}{"team": "platform", "cost-center": "1234"},
+	})
+
+	diags := resourceRpaasMetadataCreate(ctx, d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, "rpaasv2::my-rpaas", d.Id())
+	assert.Equal(t, map[string]interface{}{"team": "platform", "cost-center": "1234"}, d.Get("labels"))
+	assert.ElementsMatch(t, []types.MetadataItem{{Name: "owner", Value: "someone-else"}, {Name: "team", Value: "platform"}, {Name: "cost-center", Value: "1234"}}, labels())
+
+	// a label removed from the configuration is unset, the other ones kept
+	d = r.Data(d.State())
+	require.NoError(t, d.Set("labels", map[string]interface{}{"team": "platform"}))
+	diags = resourceRpaasMetadataUpdate(ctx, d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+	assert.ElementsMatch(t, []types.MetadataItem{{Name: "owner", Value: "someone-else"}, {Name: "team", Value: "platform"}}, labels())
+
+	// authoritative mode reads back, then unsets, every label
+	d = r.Data(d.State())
+	require.NoError(t, d.Set("mode", "authoritative"))
+	diags = resourceRpaasMetadataRead(ctx, d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, map[string]interface{}{"owner": "someone-else", "team": "platform"}, d.Get("labels"))
+
+	require.NoError(t, d.Set("labels", map[string]interface{}{"team": "platform"}))
+	diags = resourceRpaasMetadataUpdate(ctx, d, provider)
+	require.False(t, diags.HasError(), "%v", diags)
+	assert.Equal(t, []types.MetadataItem{{Name: "team", Value: "platform"}}, labels())
+
+	diags = resourceRpaasMetadataDelete(ctx, r.Data(d.State()), provider)
+	require.False(t, diags.HasError(), "%v", diags)
+	assert.Empty(t, labels())
+}
+
+func TestAccRpaasMetadata_additive(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	ctx := context.Background()
+	err := testAPIClient.SetMetadata(ctx, "my-rpaas", &types.Metadata{Labels: []types.MetadataItem{{Name: "owner", Value: "someone-else"}}})
+	require.NoError(t, err)
+
+	resourceName := "rpaas_metadata.metadata"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy: func(s *terraform.State) error {
+			metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+			require.NoError(t, err)
+			assert.Equal(t, []types.MetadataItem{{Name: "owner", Value: "someone-else"}}, metadata.Labels)
+			assert.Empty(t, metadata.Annotations)
+			return nil
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: `
+resource "rpaas_metadata" "metadata" {
+  service_name = "rpaasv2"
+  instance     = "my-rpaas"
+
+  labels = {
+    team        = "platform"
+    cost-center = "1234"
+  }
+
+  annotations = {
+    "example.com/owner" = "platform@example.com"
+  }
+}
+`,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "id", "rpaasv2::my-rpaas"),
+					resource.TestCheckResourceAttr(resourceName, "mode", "additive"),
+					resource.TestCheckResourceAttr(resourceName, "labels.%", "2"),
+					resource.TestCheckResourceAttr(resourceName, "labels.team", "platform"),
+					resource.TestCheckResourceAttr(resourceName, "annotations.example.com/owner", "platform@example.com"),
+					func(s *terraform.State) error {
+						metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+						require.NoError(t, err)
+						assert.ElementsMatch(t, []types.MetadataItem{{Name: "owner", Value: "someone-else"}, {Name: "team", Value: "platform"}, {Name: "cost-center", Value: "1234"}}, metadata.Labels)
+						return nil
+					},
+				),
+			},
+			{
+				// drift on a managed label is fixed, a removed label is unset
+				PreConfig: func() {
+					err := testAPIClient.SetMetadata(ctx, "my-rpaas", &types.Metadata{Labels: []types.MetadataItem{{Name: "team", Value: "changed"}}})
+					require.NoError(t, err)
+				},
+				Config: `
+resource "rpaas_metadata" "metadata" {
+  service_name = "rpaasv2"
+  instance     = "my-rpaas"
+
+  labels = {
+    team = "platform"
+  }
+}
+`,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "labels.%", "1"),
+					func(s *terraform.State) error {
+						metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+						require.NoError(t, err)
+						assert.ElementsMatch(t, []types.MetadataItem{{Name: "owner", Value: "someone-else"}, {Name: "team", Value: "platform"}}, metadata.Labels)
+						assert.Empty(t, metadata.Annotations)
+						return nil
+					},
+				),
+			},
+			{
+				ResourceName: resourceName,
+				ImportState:  true,
+				ImportStateCheck: func(s []*terraform.InstanceState) error {
+					require.Len(t, s, 1)
+					assert.Equal(t, "rpaasv2::my-rpaas", s[0].ID)
+					assert.Equal(t, "additive", s[0].Attributes["mode"])
+					return nil
+				},
+			},
+		},
+	})
+}
+
+func TestAccRpaasMetadata_authoritative(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	ctx := context.Background()
+	err := testAPIClient.SetMetadata(ctx, "my-rpaas", &types.Metadata{Labels: []types.MetadataItem{{Name: "owner", Value: "someone-else"}}})
+	require.NoError(t, err)
+
+	config := `
+resource "rpaas_metadata" "metadata" {
+  service_name = "rpaasv2"
+  instance     = "my-rpaas"
+  mode         = "authoritative"
+
+  labels = {
+    team = "platform"
+  }
+}
+`
+
+	resourceName := "rpaas_metadata.metadata"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		CheckDestroy: func(s *terraform.State) error {
+			metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+			require.NoError(t, err)
+			assert.Empty(t, metadata.Labels)
+			return nil
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "mode", "authoritative"),
+					resource.TestCheckResourceAttr(resourceName, "labels.%", "1"),
+					func(s *terraform.State) error {
+						metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+						require.NoError(t, err)
+						assert.Equal(t, []types.MetadataItem{{Name: "team", Value: "platform"}}, metadata.Labels)
+						return nil
+					},
+				),
+			},
+			{
+				// labels set outside of Terraform show up as drift
+				PreConfig: func() {
+					err := testAPIClient.SetMetadata(ctx, "my-rpaas", &types.Metadata{Labels: []types.MetadataItem{{Name: "owner", Value: "someone-else"}}})
+					require.NoError(t, err)
+				},
+				Config:             config,
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: config,
+				Check: func(s *terraform.State) error {
+					metadata, err := testAPIClient.GetMetadata(ctx, "my-rpaas")
+					require.NoError(t, err)
+					assert.Equal(t, []types.MetadataItem{{Name: "team", Value: "platform"}}, metadata.Labels)
+					return nil
+				},
+			},
+		},
+	})
+}
+
+func TestAccRpaasMetadata_reservedKey(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+resource "rpaas_metadata" "metadata" {
+  service_name = "rpaasv2"
+  instance     = "my-rpaas"
+
+  labels = {
+    rpaas_instance = "other"
+  }
+}
+`,
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile(`Reserved key "rpaas_instance"`),
+			},
+		},
+	})
+}
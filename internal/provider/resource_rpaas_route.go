@@ -6,38 +6,48 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
 	"strings"
+	"unicode"
 
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	rpaas_client "github.com/tsuru/rpaas-operator/pkg/rpaas/client"
 	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
 )
 
 func resourceRpaasRoute() *schema.Resource {
 	return &schema.Resource{
-		CreateContext: resourceRpaasRouteCreate,
-		ReadContext:   resourceRpaasRouteRead,
-		UpdateContext: resourceRpaasRouteUpdate,
-		DeleteContext: resourceRpaasRouteDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		CreateContext:  resourceRpaasRouteCreate,
+		ReadContext:    resourceRpaasRouteRead,
+		UpdateContext:  resourceRpaasRouteUpdate,
+		DeleteContext:  resourceRpaasRouteDelete,
+		CustomizeDiff:  resolveServiceInstanceDefaults,
+		Importer:       resourceRpaasRouteImporter(),
+		SchemaVersion:  1,
+		StateUpgraders: stateUpgradersV0(resourceRpaasRouteV0Type(), resourceRpaasRouteStateUpgradeV0),
 		Schema: map[string]*schema.Schema{
 			"instance": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Instance Name",
+				Description: "RPaaS Instance Name. Defaults to the provider `default_instance`.",
 			},
 			"service_name": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "RPaaS Service Name",
+				Description: "RPaaS Service Name. Defaults to the provider `default_service_name`.",
 			},
 			"server_name": {
 				Type:        schema.TypeString,
@@ -46,22 +56,59 @@ func resourceRpaasRoute() *schema.Resource {
 				Description: "Optional parameter used to match the server name in the location block. If not provided, it will apply to all servers.",
 			},
 			"path": {
-				Type:        schema.TypeString,
-				Required:    true,
-				ForceNew:    true,
-				Description: "Path for this route",
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+				ValidateDiagFunc: func(value interface{}, path cty.Path) diag.Diagnostics {
+					if _, err := normalizeRoutePath(value.(string)); err != nil {
+						return diag.Diagnostics{{
+							Severity:      diag.Error,
+							Summary:       "Invalid route path",
+							Detail:        err.Error(),
+							AttributePath: path,
+						}}
+					}
+					return nil
+				},
+				DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+					return sameRoutePath(old, new)
+				},
+				Description: "Path for this route. Either a prefix (`/api`), an exact match (`= /api`), a prefix that skips regex locations (`^~ /api`) or a case sensitive (`~ ^/api/v[0-9]+`) or insensitive (`~* \\.(png|jpg)$`) regular expression. Regular expressions are checked at plan time on a best-effort basis: errors after a construct Go does not support, such as a lookaround or a backreference, are only reported by nginx.",
 			},
 			"destination": {
 				Type:         schema.TypeString,
 				Optional:     true,
 				ExactlyOneOf: []string{"destination", "content"},
-				Description:  "Custom Nginx upstream destination",
+				ValidateDiagFunc: func(value interface{}, path cty.Path) diag.Diagnostics {
+					if _, err := normalizeRouteDestination(value.(string)); err != nil {
+						return diag.Diagnostics{{
+							Severity:      diag.Error,
+							Summary:       "Invalid route destination",
+							Detail:        err.Error(),
+							AttributePath: path,
+						}}
+					}
+					return nil
+				},
+				DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+					o, oerr := normalizeRouteDestination(old)
+					n, nerr := normalizeRouteDestination(new)
+					return oerr == nil && nerr == nil && o == n
+				},
+				Description: "Custom Nginx upstream destination, in the form `host` or `host:port` (e.g. `app.tsuru.io:8080`). A leading `http://` and a trailing `/` are accepted and removed; other schemes and paths are rejected.",
 			},
 			"content": {
-				Type:         schema.TypeString,
-				Optional:     true,
-				ExactlyOneOf: []string{"destination", "content"},
-				Description:  "Custom Nginx configuration content",
+				Type:             schema.TypeString,
+				Optional:         true,
+				ExactlyOneOf:     []string{"destination", "content"},
+				DiffSuppressFunc: suppressEquivalentNginxConfig,
+				Description:      "Custom Nginx configuration content. Whitespace-only differences are ignored.",
+			},
+			"ignore_comments": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Whether changes only on comments of the Nginx configuration should be ignored when comparing it against the content stored on RPaaS.",
 			},
 			"https_only": {
 				Type:        schema.TypeBool,
@@ -69,6 +116,7 @@ func resourceRpaasRoute() *schema.Resource {
 				Default:     false,
 				Description: "Only on https",
 			},
+			"wait_for_rollout": waitForRolloutSchema(),
 		},
 	}
 }
@@ -79,11 +127,10 @@ func resourceRpaasRouteCreate(ctx context.Context, d *schema.ResourceData, meta
 	instance := d.Get("instance").(string)
 	serviceName := d.Get("service_name").(string)
 	serverName := d.Get("server_name").(string)
-	path := d.Get("path").(string)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
+	path, err := normalizeRoutePath(d.Get("path").(string))
 	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
+		return diag.Errorf("Invalid route path: %v", err)
 	}
 
 	tflog.Info(ctx, "Create route", map[string]interface{}{
@@ -93,21 +140,28 @@ func resourceRpaasRouteCreate(ctx context.Context, d *schema.ResourceData, meta
 		"path":       path,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutCreate), func() (*http.Response, error) {
-		return nil, updateRpaasRoute(ctx, d, instance, serverName, path, rpaasClient)
+		return updateRpaasRoute(ctx, d, serverName, path, provider.Client(serviceName, instance))
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to create route %s for instance %s: %v", path, instance, err)
 	}
 
-	if serverName == "" {
-		d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, path))
-	} else {
-		d.SetId(fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, serverName, path))
+	d.SetId(buildRpaasRouteID(serviceName, instance, serverName, path))
+
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutCreate))
+	if diags.HasError() {
+		return diags
 	}
 
-	return resourceRpaasRouteRead(ctx, d, meta)
+	return append(diags, resourceRpaasRouteRead(ctx, d, meta)...)
 }
 
 func resourceRpaasRouteUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -118,11 +172,6 @@ func resourceRpaasRouteUpdate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("Unable to parse Route ID: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Update route", map[string]interface{}{
 		"service":    serviceName,
 		"instance":   instance,
@@ -130,15 +179,26 @@ func resourceRpaasRouteUpdate(ctx context.Context, d *schema.ResourceData, meta
 		"path":       path,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutUpdate), func() (*http.Response, error) {
-		return nil, updateRpaasRoute(ctx, d, instance, serverName, path, rpaasClient)
+		return updateRpaasRoute(ctx, d, serverName, path, provider.Client(serviceName, instance))
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to update route %s for instance %s: %v", path, instance, err)
 	}
 
-	return resourceRpaasRouteRead(ctx, d, meta)
+	diags := waiter.Wait(ctx, d.Timeout(schema.TimeoutUpdate))
+	if diags.HasError() {
+		return diags
+	}
+
+	return append(diags, resourceRpaasRouteRead(ctx, d, meta)...)
 }
 
 func resourceRpaasRouteRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -153,25 +213,32 @@ func resourceRpaasRouteRead(ctx context.Context, d *schema.ResourceData, meta in
 	d.Set("service_name", serviceName)
 	d.Set("server_name", serverName)
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
+	// states written before ignore_comments existed, and imports, lack it
+	if _, ok := d.GetOk("ignore_comments"); !ok {
+		d.Set("ignore_comments", false)
 	}
 
 	var routes []types.Route
 
-	rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
-		r, nerr := rpaasClient.ListRoutes(ctx, rpaas_client.ListRoutesArgs{Instance: instance})
+	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutRead), func() (*http.Response, error) {
+		r, response, nerr := cachedRead(ctx, provider.cache, serviceName, instance, "routes", func(ctx context.Context) ([]types.Route, *http.Response, error) {
+			return provider.Client(serviceName, instance).ListRoutes(ctx)
+		})
 		if nerr != nil {
-			return nil, nerr
+			return response, nerr
 		}
 
 		routes = r
-		return nil, nil
+		return response, nil
 	})
 
+	if isNotFoundError(err) {
+		d.SetId("")
+		return nil
+	}
+
 	if err != nil {
-		return diag.Errorf("Unable to get block %s for instance %s: %v", path, instance, err)
+		return diag.Errorf("Unable to get route %s for instance %s: %v", path, instance, err)
 	}
 
 	// auto-fix old buggy ID
@@ -186,17 +253,13 @@ func resourceRpaasRouteRead(ctx context.Context, d *schema.ResourceData, meta in
 	}
 
 	for _, b := range routes {
-		if b.ServerName == serverName && b.Path == path {
+		if b.ServerName == serverName && sameRoutePath(b.Path, path) {
 			d.Set("path", b.Path)
 			d.Set("https_only", b.HTTPSOnly)
 			d.Set("destination", b.Destination)
 			d.Set("content", b.Content)
 
-			if serverName == "" {
-				d.SetId(fmt.Sprintf("%s::%s::%s", serviceName, instance, path))
-			} else {
-				d.SetId(fmt.Sprintf("%s::%s::%s::%s", serviceName, instance, serverName, path))
-			}
+			d.SetId(buildRpaasRouteID(serviceName, instance, serverName, path))
 			return nil
 		}
 	}
@@ -214,11 +277,6 @@ func resourceRpaasRouteDelete(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("Unable to parse Route ID: %v", err)
 	}
 
-	rpaasClient, err := provider.RpaasClient.SetService(serviceName)
-	if err != nil {
-		return diag.Errorf("Unable to create client for service %s: %v", serviceName, err)
-	}
-
 	tflog.Info(ctx, "Delete route", map[string]interface{}{
 		"service":    serviceName,
 		"instance":   instance,
@@ -226,33 +284,43 @@ func resourceRpaasRouteDelete(ctx context.Context, d *schema.ResourceData, meta
 		"path":       path,
 	})
 
+	waiter, err := newRolloutWaiter(ctx, d, provider, serviceName, instance)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	err = rpaasRetry(ctx, d.Timeout(schema.TimeoutDelete), func() (*http.Response, error) {
-		return nil, rpaasClient.DeleteRoute(ctx, rpaas_client.DeleteRouteArgs{
-			Instance:   instance,
-			ServerName: serverName,
-			Path:       path,
-		})
+		return provider.Client(serviceName, instance).DeleteRoute(ctx, serverName, path)
 	})
+	provider.cache.Invalidate(serviceName, instance)
 
 	if err != nil {
 		return diag.Errorf("Unable to remove route for instance %s: %v", instance, err)
 	}
 
-	return nil
+	return waiter.Wait(ctx, d.Timeout(schema.TimeoutDelete))
+}
+
+func buildRpaasRouteID(serviceName, instance, serverName, path string) string {
+	if serverName == "" {
+		return buildID(serviceName, instance, path)
+	}
+
+	return buildID(serviceName, instance, serverName, path)
 }
 
 func parseRpaasRouteID(id string) (serviceName, instance, serverName, path string, err error) {
-	splitID := strings.Split(id, "::")
-
-	if len(splitID) == 4 {
-		serviceName = splitID[0]
-		instance = splitID[1]
-		serverName = splitID[2]
-		path = splitID[3]
-	} else if len(splitID) == 3 {
-		serviceName = splitID[0]
-		instance = splitID[1]
-		path = splitID[2]
+	parts := splitID(id)
+
+	if len(parts) == 4 {
+		serviceName = parts[0]
+		instance = parts[1]
+		serverName = parts[2]
+		path = parts[3]
+	} else if len(parts) == 3 {
+		serviceName = parts[0]
+		instance = parts[1]
+		path = parts[2]
 	} else {
 		serviceName, instance, err = parseRpaasRouteID_legacyV0(id)
 	}
@@ -260,22 +328,126 @@ func parseRpaasRouteID(id string) (serviceName, instance, serverName, path strin
 	return
 }
 
-func updateRpaasRoute(ctx context.Context, d *schema.ResourceData, instance, serverName, path string, rpaasClient rpaas_client.Client) error {
-	args := rpaas_client.UpdateRouteArgs{
-		Instance:   instance,
+var routePathModifiers = []string{"=", "^~", "~*", "~"}
+
+func isRoutePathPrefix(path string) bool {
+	if strings.HasPrefix(path, "/") {
+		return true
+	}
+
+	for _, m := range routePathModifiers {
+		if strings.HasPrefix(path, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeRoutePath validates a route path as a Nginx location argument and
+// returns it with a single space between the modifier and the pattern.
+func normalizeRoutePath(path string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", fmt.Errorf("path must not be empty")
+	}
+
+	modifier, pattern := "", path
+	for _, m := range routePathModifiers {
+		if strings.HasPrefix(path, m) {
+			modifier, pattern = m, strings.TrimSpace(strings.TrimPrefix(path, m))
+			break
+		}
+	}
+
+	if pattern == "" {
+		return "", fmt.Errorf("path %q has a modifier but no pattern", path)
+	}
+
+	if modifier == "~" || modifier == "~*" {
+		if err := validateRoutePathRegexp(path, pattern); err != nil {
+			return "", err
+		}
+		return modifier + " " + pattern, nil
+	}
+
+	if !strings.HasPrefix(pattern, "/") {
+		return "", fmt.Errorf("path %q must start with \"/\"", path)
+	}
+
+	if strings.ContainsAny(pattern, ";{}") || strings.ContainsFunc(pattern, unicode.IsSpace) {
+		return "", fmt.Errorf("path %q must not contain whitespace, \";\", \"{\" or \"}\"", path)
+	}
+
+	if modifier == "" {
+		return pattern, nil
+	}
+
+	return modifier + " " + pattern, nil
+}
+
+func validateRoutePathRegexp(path, pattern string) error {
+	expr := pattern
+	if n := len(pattern); n >= 2 && (pattern[0] == '"' || pattern[0] == '\'') && pattern[n-1] == pattern[0] {
+		expr = pattern[1 : n-1]
+	} else if strings.ContainsAny(pattern, ";{}") || strings.ContainsFunc(pattern, unicode.IsSpace) {
+		return fmt.Errorf("path %q has a regular expression with whitespace, \";\", \"{\" or \"}\", so it must be enclosed in quotes", path)
+	}
+
+	if _, err := syntax.Parse(expr, syntax.Perl); err != nil {
+		var serr *syntax.Error
+		// nginx uses PCRE, which supports much more than Go (e.g. lookarounds,
+		// backreferences and possessive quantifiers), so only errors that are
+		// errors in PCRE too are reported. This is best-effort: Go stops at
+		// the first construct it does not support, so a PCRE error after it
+		// goes unnoticed until nginx loads the configuration.
+		if errors.As(err, &serr) && invalidPCRECodes[serr.Code] {
+			return fmt.Errorf("path %q has an invalid regular expression: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// invalidPCRECodes are the errors parsing a Go regular expression that make
+// it an invalid PCRE one as well, when Go raises them before reaching a
+// construct only PCRE supports.
+var invalidPCRECodes = map[syntax.ErrorCode]bool{
+	syntax.ErrMissingBracket:    true,
+	syntax.ErrMissingParen:      true,
+	syntax.ErrTrailingBackslash: true,
+	syntax.ErrUnexpectedParen:   true,
+}
+
+func sameRoutePath(a, b string) bool {
+	na, aerr := normalizeRoutePath(a)
+	nb, berr := normalizeRoutePath(b)
+	if aerr != nil || berr != nil {
+		return a == b
+	}
+
+	return na == nb
+}
+
+func updateRpaasRoute(ctx context.Context, d *schema.ResourceData, serverName, path string, client *rpaasAPI) (*http.Response, error) {
+	route := types.Route{
 		ServerName: serverName,
 		Path:       path,
 		HTTPSOnly:  d.Get("https_only").(bool),
 	}
 
 	if content, ok := d.GetOk("content"); ok {
-		args.Content = content.(string)
+		route.Content = content.(string)
 	}
 	if destination, ok := d.GetOk("destination"); ok {
-		args.Destination = destination.(string)
+		normalized, err := normalizeRouteDestination(destination.(string))
+		if err != nil {
+			return nil, err
+		}
+		route.Destination = normalized
 	}
 
-	return rpaasClient.UpdateRoute(ctx, args)
+	return client.UpdateRoute(ctx, route)
 }
 
 func parseRpaasRouteID_legacyV0(id string) (serviceName, instance string, err error) {
@@ -289,3 +461,114 @@ func parseRpaasRouteID_legacyV0(id string) (serviceName, instance string, err er
 	instance = splitID[1]
 	return
 }
+
+var hostnameRegexp = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*\.?$`)
+
+// normalizeRouteDestination returns the canonical form of a route
+// destination, which is used by Nginx as an upstream server and as the Host
+// header: a lowercase hostname (or IP address) optionally followed by a port.
+func normalizeRouteDestination(destination string) (string, error) {
+	destination = strings.TrimSpace(destination)
+	if destination == "" {
+		return "", nil
+	}
+
+	if strings.Contains(destination, "://") {
+		u, err := url.Parse(destination)
+		if err != nil {
+			return "", fmt.Errorf("could not parse destination %q: %v", destination, err)
+		}
+
+		if !strings.EqualFold(u.Scheme, "http") {
+			return "", fmt.Errorf("destination %q uses scheme %q, but routes are always proxied over plain HTTP. Use \"host\" or \"host:port\" instead", destination, u.Scheme)
+		}
+
+		if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return "", fmt.Errorf("destination %q must not have credentials, path, query or fragment. Use \"host\" or \"host:port\" instead", destination)
+		}
+
+		destination = u.Host
+	} else {
+		destination = strings.TrimSuffix(destination, "/")
+	}
+
+	if strings.ContainsAny(destination, "/?#@ ") {
+		return "", fmt.Errorf("destination %q must not have path, query or credentials. Use \"host\" or \"host:port\" instead", destination)
+	}
+
+	host, port := destination, ""
+	if strings.HasPrefix(destination, "[") && strings.HasSuffix(destination, "]") {
+		host = strings.Trim(destination, "[]")
+	} else if strings.HasPrefix(destination, "[") || strings.Count(destination, ":") == 1 {
+		var err error
+		host, port, err = net.SplitHostPort(destination)
+		if err != nil {
+			return "", fmt.Errorf("invalid destination %q: %v", destination, err)
+		}
+
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port %q in destination %q", port, destination)
+		}
+	}
+
+	host = strings.ToLower(host)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			host = "[" + ip.String() + "]"
+		}
+	} else if len(host) > 253 || !hostnameRegexp.MatchString(host) {
+		return "", fmt.Errorf("invalid hostname %q in destination %q", host, destination)
+	}
+
+	if port == "" {
+		return host, nil
+	}
+
+	return host + ":" + port, nil
+}
+
+var rpaasRouteID = resourceIDFormat{
+	Required: []string{"service_name", "instance", "path"},
+	Optional: []string{"server_name"},
+	Parse: func(id string) (map[string]string, error) {
+		serviceName, instance, serverName, path, err := parseRpaasRouteID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := map[string]string{"service_name": serviceName, "instance": instance, "server_name": serverName}
+		if path != "" { // legacy IDs have no path
+			attributes["path"] = path
+		}
+		return attributes, nil
+	},
+	Build: func(a map[string]string) string {
+		return buildRpaasRouteID(a["service_name"], a["instance"], a["server_name"], a["path"])
+	},
+}
+
+func resourceRpaasRouteImporter() *schema.ResourceImporter {
+	importer := &resourceImporter{
+		Resource: "rpaas_route",
+		Keys:     []string{"path", "server_name"},
+		ParseID:  rpaasRouteID.Parse,
+		List: func(ctx context.Context, provider *rpaasProvider, serviceName, instance string) ([]importCandidate, error) {
+			routes, _, err := provider.Client(serviceName, instance).ListRoutes(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var candidates []importCandidate
+			for _, r := range routes {
+				candidates = append(candidates, importCandidate{
+					ID:         buildRpaasRouteID(serviceName, instance, r.ServerName, r.Path),
+					Attributes: map[string]string{"path": r.Path, "server_name": r.ServerName},
+				})
+			}
+			return candidates, nil
+		},
+		Equal: map[string]func(a, b string) bool{"path": sameRoutePath},
+	}
+
+	return importer.Importer()
+}
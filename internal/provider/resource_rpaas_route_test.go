@@ -7,6 +7,7 @@ package provider
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
@@ -215,6 +216,20 @@ func TestAccRpaasRoute_import(t *testing.T) {
 					assert.Equal(t, "/path/1", state.Attributes["path"])
 					assert.Equal(t, "true", state.Attributes["https_only"])
 					assert.Equal(t, "http://infinity-and-beyond:5555", state.Attributes["destination"])
+					assert.Equal(t, "false", state.Attributes["ignore_comments"])
+					return nil
+				},
+			},
+			{
+				// Testing Import by attributes
+				Config:        `resource "rpaas_route" "imported" {}`,
+				ResourceName:  "rpaas_route.imported",
+				ImportStateId: "service_name=rpaasv2-be,instance=my-rpaas,path=/path/1",
+				ImportState:   true,
+				ImportStateCheck: func(s []*terraform.InstanceState) error {
+					state := s[0]
+					assert.Len(t, s, 1)
+					assert.Equal(t, "rpaasv2-be::my-rpaas::/path/1", state.Attributes["id"])
 					return nil
 				},
 			},
@@ -235,6 +250,265 @@ func TestAccRpaasRoute_import(t *testing.T) {
 	})
 }
 
+func TestAccRpaasRoute_equivalentContent(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_route.custom_route"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccRpaasRouteConfigInline("/", "return 200 'ok';"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "content", "return 200 'ok';"),
+				),
+			},
+			{
+				// Server-side content reformatted: no diff expected
+				PreConfig: func() {
+					err := testAPIClient.UpdateRoute(context.Background(), client.UpdateRouteArgs{Instance: "my-rpaas", Path: "/", Content: "  return   200 'ok';\n\n"})
+					assert.NoError(t, err)
+				},
+				Config:   testAccRpaasRouteConfigInline("/", "return 200 'ok';"),
+				PlanOnly: true,
+			},
+			{
+				// Comment only changes are ignored when asked to
+				Config:   testAccRpaasRouteConfigInline("/", "# healthcheck\nreturn 200 'ok';"),
+				PlanOnly: true,
+			},
+			{
+				Config:             testAccRpaasRouteConfigInline("/", "return 200 'not ok';"),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+func TestAccRpaasRoute_destination(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_route.custom_route"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccRpaasRouteConfigWithDestination("/", "https://app.tsuru.io"),
+				ExpectError: regexp.MustCompile(`uses scheme "https"`),
+			},
+			{
+				Config: testAccRpaasRouteConfigWithDestination("/", "http://App.Tsuru.io:8080/"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "destination", "app.tsuru.io:8080"),
+					func(s *terraform.State) error {
+						routes, err := testAPIClient.ListRoutes(context.Background(), client.ListRoutesArgs{Instance: "my-rpaas"})
+						assert.NoError(t, err)
+						assert.Len(t, routes, 1)
+						assert.Equal(t, "app.tsuru.io:8080", routes[0].Destination)
+						return nil
+					},
+				),
+			},
+			{
+				Config:   testAccRpaasRouteConfigWithDestination("/", "app.tsuru.io:8080"),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestNormalizeRouteDestination(t *testing.T) {
+	tests := []struct {
+		destination   string
+		expected      string
+		expectedError string
+	}{
+		{destination: "app.tsuru.io", expected: "app.tsuru.io"},
+		{destination: " App.Tsuru.IO:8080 ", expected: "app.tsuru.io:8080"},
+		{destination: "app.tsuru.io/", expected: "app.tsuru.io"},
+		{destination: "http://app.tsuru.io", expected: "app.tsuru.io"},
+		{destination: "HTTP://app.tsuru.io:80/", expected: "app.tsuru.io:80"},
+		{destination: "10.0.0.1:8888", expected: "10.0.0.1:8888"},
+		{destination: "[2001:DB8::1]:8080", expected: "[2001:db8::1]:8080"},
+		{destination: "2001:db8::1", expected: "[2001:db8::1]"},
+		{destination: "[2001:db8::1]", expected: "[2001:db8::1]"},
+		{destination: "my-svc.ns.svc.cluster.local.", expected: "my-svc.ns.svc.cluster.local."},
+		{destination: "", expected: ""},
+		{destination: "https://app.tsuru.io", expectedError: `destination "https://app.tsuru.io" uses scheme "https", but routes are always proxied over plain HTTP. Use "host" or "host:port" instead`},
+		{destination: "http://app.tsuru.io/api", expectedError: `destination "http://app.tsuru.io/api" must not have credentials, path, query or fragment. Use "host" or "host:port" instead`},
+		{destination: "app.tsuru.io/api", expectedError: `destination "app.tsuru.io/api" must not have path, query or credentials. Use "host" or "host:port" instead`},
+		{destination: "app.tsuru.io:http", expectedError: `invalid port "http" in destination "app.tsuru.io:http"`},
+		{destination: "app.tsuru.io:70000", expectedError: `invalid port "70000" in destination "app.tsuru.io:70000"`},
+		{destination: "app_tsuru.io", expectedError: `invalid hostname "app_tsuru.io" in destination "app_tsuru.io"`},
+		{destination: "-app.tsuru.io", expectedError: `invalid hostname "-app.tsuru.io" in destination "-app.tsuru.io"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			normalized, err := normalizeRouteDestination(tt.destination)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, normalized)
+		})
+	}
+}
+
+func TestAccRpaasRoute_path(t *testing.T) {
+	testAPIClient, testAPIServer := setupTestAPIServer(t)
+	defer testAPIServer.Stop()
+
+	resourceName := "rpaas_route.custom_route"
+	resource.Test(t, resource.TestCase{
+		PreCheck:          func() { testAccPreCheck(t) },
+		IDRefreshName:     resourceName,
+		ProviderFactories: testAccProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccRpaasRouteConfig("~ ^/api/(v1", "# nginx config"),
+				ExpectError: regexp.MustCompile(`has an invalid regular expression`),
+			},
+			{
+				Config:      testAccRpaasRouteConfig("api", "# nginx config"),
+				ExpectError: regexp.MustCompile(`must start with "/"`),
+			},
+			{
+				Config: testAccRpaasRouteConfigWithServername("example.org", "/api::v1", "# nginx config"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					testAccResourceExists(resourceName),
+					resource.TestCheckResourceAttr(resourceName, "id", "rpaasv2-be::my-rpaas::example.org::/api%3A%3Av1"),
+					resource.TestCheckResourceAttr(resourceName, "server_name", "example.org"),
+					resource.TestCheckResourceAttr(resourceName, "path", "/api::v1"),
+					func(s *terraform.State) error {
+						routes, err := testAPIClient.ListRoutes(context.Background(), client.ListRoutesArgs{Instance: "my-rpaas"})
+						assert.NoError(t, err)
+						assert.Len(t, routes, 1)
+						assert.Equal(t, "/api::v1", routes[0].Path)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestNormalizeRoutePath(t *testing.T) {
+	tests := []struct {
+		path          string
+		expected      string
+		expectedError string
+	}{
+		{path: "/", expected: "/"},
+		{path: " /api/v1 ", expected: "/api/v1"},
+		{path: "/api::v1", expected: "/api::v1"},
+		{path: "=/exact", expected: "= /exact"},
+		{path: "=   /exact", expected: "= /exact"},
+		{path: "^~ /static/", expected: "^~ /static/"},
+		{path: "~ ^/api/v[0-9]+", expected: "~ ^/api/v[0-9]+"},
+		{path: "~*\\.(png|jpg)$", expected: "~* \\.(png|jpg)$"},
+		{path: "~ \"^/a{2}\"", expected: "~ \"^/a{2}\""},
+		{path: "~ ^/(?!admin)", expected: "~ ^/(?!admin)"},
+		{path: "~ (?<=/api)/v1", expected: "~ (?<=/api)/v1"},
+		{path: "~ ^/(a)\\1$", expected: "~ ^/(a)\\1$"},
+		{path: "~ ^/(?<n>a)\\k<n>$", expected: "~ ^/(?<n>a)\\k<n>$"},
+		{path: "~ ^/a++", expected: "~ ^/a++"},
+		{path: "~ ^/(?>a+)b", expected: "~ ^/(?>a+)b"},
+		// best-effort: Go stops at the lookahead, so the missing ] is left to nginx
+		{path: "~ ^/(?!admin)[a-z", expected: "~ ^/(?!admin)[a-z"},
+		{path: "", expectedError: "path must not be empty"},
+		{path: "api", expectedError: `path "api" must start with "/"`},
+		{path: "= api", expectedError: `path "= api" must start with "/"`},
+		{path: "~", expectedError: `path "~" has a modifier but no pattern`},
+		{path: "/a b", expectedError: `path "/a b" must not contain whitespace, ";", "{" or "}"`},
+		{path: "/a;", expectedError: `path "/a;" must not contain whitespace, ";", "{" or "}"`},
+		{path: "~ ^/a{2}", expectedError: `path "~ ^/a{2}" has a regular expression with whitespace, ";", "{" or "}", so it must be enclosed in quotes`},
+		{path: "~ ^/v1)", expectedError: "path \"~ ^/v1)\" has an invalid regular expression: error parsing regexp: unexpected ): `^/v1)`"},
+		{path: "~ ^/[a-z", expectedError: "path \"~ ^/[a-z\" has an invalid regular expression: error parsing regexp: missing closing ]: `[a-z`"},
+		{path: "~ ^/(v1", expectedError: "path \"~ ^/(v1\" has an invalid regular expression: error parsing regexp: missing closing ): `^/(v1`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			normalized, err := normalizeRoutePath(tt.path)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, normalized)
+		})
+	}
+}
+
+func TestParseRpaasRouteID(t *testing.T) {
+	tests := []struct {
+		id                                  string
+		service, instance, serverName, path string
+		expectedError                       string
+	}{
+		{id: "rpaasv2::my-rpaas::/", service: "rpaasv2", instance: "my-rpaas", path: "/"},
+		{id: "rpaasv2::my-rpaas::example.org::/", service: "rpaasv2", instance: "my-rpaas", serverName: "example.org", path: "/"},
+		{id: "rpaasv2::my-rpaas::/api%3A%3Av1", service: "rpaasv2", instance: "my-rpaas", path: "/api::v1"},
+		{id: "rpaasv2::my-rpaas::example.org::/api%3A%3Av1", service: "rpaasv2", instance: "my-rpaas", serverName: "example.org", path: "/api::v1"},
+		{id: "rpaasv2::my-rpaas::~ ^/(a|b)%3A%3Ac$", service: "rpaasv2", instance: "my-rpaas", path: "~ ^/(a|b)::c$"},
+		{id: "rpaasv2::my-rpaas::/caf%25C3%25A9", service: "rpaasv2", instance: "my-rpaas", path: "/caf%C3%A9"},
+		{id: "rpaasv2::my-rpaas::example.org::= /exact", service: "rpaasv2", instance: "my-rpaas", serverName: "example.org", path: "= /exact"},
+		{id: "rpaasv2/my-rpaas", service: "rpaasv2", instance: "my-rpaas"},
+		{id: "rpaasv2::my-rpaas::/%zz", service: "rpaasv2", instance: "my-rpaas", path: "/%zz"},
+		{id: "rpaasv2::my-rpaas::/a%20b", service: "rpaasv2", instance: "my-rpaas", path: "/a%20b"},
+		{id: "rpaasv2", expectedError: `Could not parse id "rpaasv2". Format should be "service::instance::path" or "service::instance::serverName::path"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			service, instance, serverName, path, err := parseRpaasRouteID(tt.id)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.service, service)
+			assert.Equal(t, tt.instance, instance)
+			assert.Equal(t, tt.serverName, serverName)
+			assert.Equal(t, tt.path, path)
+		})
+	}
+}
+
+func testAccRpaasRouteConfigWithDestination(path, destination string) string {
+	return fmt.Sprintf(`
+resource "rpaas_route" "custom_route" {
+	instance     = "my-rpaas"
+	service_name = "rpaasv2-be"
+
+	path        = %q
+	destination = %q
+}
+`, path, destination)
+}
+
+func testAccRpaasRouteConfigInline(path, content string) string {
+	return fmt.Sprintf(`
+resource "rpaas_route" "custom_route" {
+	instance     = "my-rpaas"
+	service_name = "rpaasv2-be"
+
+	path            = %q
+	content         = %q
+	ignore_comments = true
+}
+`, path, content)
+}
+
 func testAccRpaasRouteConfig(path, content string) string {
 	return fmt.Sprintf(`
 resource "rpaas_route" "custom_route" {
@@ -0,0 +1,266 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	rpaastypes "github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+var (
+	// rolloutPollInterval is how often the pods are checked while waiting.
+	rolloutPollInterval = 5 * time.Second
+
+	// rolloutGracePeriod is how long new pods may take to show up. Changes
+	// rendering the same nginx configuration roll out no pods, so the
+	// rollout is done once every pod is ready after it.
+	rolloutGracePeriod = time.Minute
+)
+
+func waitForRolloutSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Description: "Whether to wait, after each change, until every pod of the instance runs the new nginx configuration and is ready, failing the change when the rollout is not done within the resource timeout. Changes rendering the same configuration roll out no pods: they are done once every pod is ready and no new pod showed up for a minute. Defaults to the provider `wait_for_rollout`.",
+	}
+}
+
+// podRevision returns the nginx configuration revision a pod runs: the pod
+// template hash in the name of the pods of a Deployment, such as "5d8f7c9b4"
+// in "my-rpaas-5d8f7c9b4-x2k9z". The operator changes the pod template, thus
+// the hash, whenever the configuration, files or certificates change. The
+// RPaaS API reports neither the labels nor the owner of the pods, so the
+// name is all there is. It returns "" for names without a hash.
+func podRevision(p rpaastypes.Pod) string {
+	parts := strings.Split(p.Name, "-")
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[len(parts)-2]
+}
+
+// waitForRollout tells whether the resource should wait for rollouts: its
+// own wait_for_rollout, either configured or stored in the state on
+// deletion, or else the provider one.
+func waitForRollout(d *schema.ResourceData, provider *rpaasProvider) bool {
+	for _, raw := range []cty.Value{d.GetRawConfig(), d.GetRawState()} {
+		if raw.IsNull() || !raw.IsKnown() || !raw.Type().IsObjectType() || !raw.Type().HasAttribute("wait_for_rollout") {
+			continue
+		}
+
+		if v := raw.GetAttr("wait_for_rollout"); v.IsKnown() && !v.IsNull() {
+			return v.True()
+		}
+	}
+
+	return provider.opts.WaitForRollout
+}
+
+// rolloutWaiter waits for the rollout of the nginx configuration changed
+// after it was created, by polling the pods of the instance.
+type rolloutWaiter struct {
+	client   *rpaasAPI
+	instance string
+	previous map[string]bool
+	started  time.Time
+}
+
+// newRolloutWaiter must be called before the change, to take note of the
+// revisions the pods run. It returns nil, which waits for nothing, when the
+// resource does not wait for rollouts or only wait_for_rollout changes.
+func newRolloutWaiter(ctx context.Context, d *schema.ResourceData, provider *rpaasProvider, serviceName, instance string) (*rolloutWaiter, error) {
+	if !waitForRollout(d, provider) {
+		return nil, nil
+	}
+
+	if d.Id() != "" && !d.GetRawConfig().IsNull() && !d.HasChangesExcept("wait_for_rollout") {
+		return nil, nil
+	}
+
+	client := provider.Client(serviceName, instance)
+	pods, _, err := client.Pods(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get pods of instance %s: %w", instance, err)
+	}
+
+	previous := make(map[string]bool)
+	for _, p := range pods {
+		if revision := podRevision(p); revision != "" {
+			previous[revision] = true
+		}
+	}
+
+	return &rolloutWaiter{client: client, instance: instance, previous: previous}, nil
+}
+
+// Wait waits until every pod runs a single new revision and is ready. Pods
+// of the new revision crashlooping fail it right away, with their errors.
+// When no new revision shows up within the grace period, the change is taken
+// as rendering the same configuration, and it waits for every pod to be
+// ready. Instances without pods, as when scaled to zero, are not waited for.
+func (w *rolloutWaiter) Wait(ctx context.Context, timeout time.Duration) diag.Diagnostics {
+	if w == nil {
+		return nil
+	}
+
+	w.started = time.Now()
+
+	var status string
+
+	conf := &retry.StateChangeConf{
+		Pending:      []string{"pending", "rolling out"},
+		Target:       []string{"done"},
+		Timeout:      timeout,
+		PollInterval: rolloutPollInterval,
+		Refresh: func() (interface{}, string, error) {
+			pods, _, err := w.client.Pods(ctx)
+			if err != nil {
+				return nil, "", fmt.Errorf("Unable to get pods of instance %s: %w", w.instance, err)
+			}
+
+			var state string
+			state, status, err = w.check(pods)
+
+			tflog.Debug(ctx, "Wait for rollout", map[string]interface{}{
+				"instance": w.instance,
+				"state":    state,
+				"status":   status,
+			})
+			return pods, state, err
+		},
+	}
+
+	_, err := conf.WaitForStateContext(ctx)
+
+	var crashlooping *crashloopError
+	if errors.As(err, &crashlooping) {
+		var diags diag.Diagnostics
+		for _, p := range crashlooping.Pods {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Pod %s of instance %s is crashlooping after %d restarts", p.Name, w.instance, p.Restarts),
+				Detail:   podErrorMessages(p),
+			})
+		}
+		return diags
+	}
+
+	var timeoutErr *retry.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return diag.Errorf("Timeout waiting for the rollout of instance %s: %s", w.instance, status)
+	}
+
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return nil
+}
+
+// check returns the state of the rollout and a description of it. Pods not
+// named after a pod template hash run an unknown revision: they only have to
+// be ready.
+func (w *rolloutWaiter) check(pods []rpaastypes.Pod) (string, string, error) {
+	if len(pods) == 0 {
+		return "done", "the instance has no pods", nil
+	}
+
+	var updated, ready, unready, terminating int
+	var crashlooping []rpaastypes.Pod
+	var waiting []string
+	revisions := make(map[string]bool)
+
+	for _, p := range pods {
+		if p.Status == "Terminating" {
+			terminating++
+			continue
+		}
+
+		podReady := p.Ready && p.Status == "Running"
+		if !podReady {
+			unready++
+		}
+
+		revision := podRevision(p)
+		if revision != "" {
+			revisions[revision] = true
+		}
+		if revision == "" || w.previous[revision] {
+			continue
+		}
+
+		updated++
+		switch {
+		case podReady:
+			ready++
+		case p.Restarts > 0 && len(p.Errors) > 0:
+			crashlooping = append(crashlooping, p)
+		default:
+			waiting = append(waiting, fmt.Sprintf("pod %s is %s: %s", p.Name, p.Status, podErrorMessages(p)))
+		}
+	}
+
+	if len(crashlooping) > 0 {
+		return "", "", &crashloopError{Pods: crashlooping}
+	}
+
+	if updated == 0 {
+		if unready == 0 && terminating == 0 && time.Since(w.started) >= rolloutGracePeriod {
+			return "done", "no pod runs a new nginx configuration, every pod is ready", nil
+		}
+
+		return "pending", fmt.Sprintf("no pod runs the new nginx configuration yet, %d of %d pods not ready", unready, len(pods)-terminating), nil
+	}
+
+	status := fmt.Sprintf("%d of %d pods run the new nginx configuration, %d of them ready, %d terminating", updated, len(pods)-terminating, ready, terminating)
+	if len(waiting) > 0 {
+		status += "; " + strings.Join(waiting, "; ")
+	}
+
+	if len(revisions) == 1 && unready == 0 && terminating == 0 {
+		return "done", status, nil
+	}
+
+	return "rolling out", status, nil
+}
+
+type crashloopError struct {
+	Pods []rpaastypes.Pod
+}
+
+func (e *crashloopError) Error() string {
+	names := make([]string, 0, len(e.Pods))
+	for _, p := range e.Pods {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+
+	return fmt.Sprintf("pods crashlooping: %s", strings.Join(names, ", "))
+}
+
+func podErrorMessages(p rpaastypes.Pod) string {
+	if len(p.Errors) == 0 {
+		return "no errors reported"
+	}
+
+	messages := make([]string, 0, len(p.Errors))
+	for _, e := range p.Errors {
+		messages = append(messages, e.Message)
+	}
+
+	return strings.Join(messages, "\n")
+}
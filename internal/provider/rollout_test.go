@@ -0,0 +1,287 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rpaastypes "github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+// newTestPodsServer serves the info of my-rpaas with each list of pods in
+// turn, the last one repeatedly.
+func newTestPodsServer(t *testing.T, podLists ...[]rpaastypes.Pod) (*rpaasProvider, func() int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/resources/my-rpaas/info", r.URL.Path)
+
+		mu.Lock()
+		pods := podLists[min(calls, len(podLists)-1)]
+		calls++
+		mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "my-rpaas", "pods": pods})
+	}))
+	t.Cleanup(server.Close)
+
+	provider := &rpaasProvider{opts: &ProviderConfigOptions{URL: server.URL, Username: "admin", Password: "admin"}}
+	return provider, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func setTestRolloutPollInterval(t *testing.T, pollInterval time.Duration) {
+	oldPollInterval := rolloutPollInterval
+	rolloutPollInterval = pollInterval
+	t.Cleanup(func() { rolloutPollInterval = oldPollInterval })
+}
+
+func setTestRolloutGracePeriod(t *testing.T, gracePeriod time.Duration) {
+	oldGracePeriod := rolloutGracePeriod
+	rolloutGracePeriod = gracePeriod
+	t.Cleanup(func() { rolloutGracePeriod = oldGracePeriod })
+}
+
+// testRawObject returns an object of the resource type, with the values
+// given and the other attributes null.
+func testRawObject(r *schema.Resource, values map[string]cty.Value) cty.Value {
+	attributes := make(map[string]cty.Value)
+	for name, t := range r.CoreConfigSchema().ImpliedType().AttributeTypes() {
+		attributes[name] = cty.NullVal(t)
+		if v, ok := values[name]; ok {
+			attributes[name] = v
+		}
+	}
+	return cty.ObjectVal(attributes)
+}
+
+// testRolloutResourceData returns the data of a block, configured with the
+// wait_for_rollout given, as seen on creation.
+func testRolloutResourceData(waitForRollout cty.Value) *schema.ResourceData {
+	r := resourceRpaasBlock()
+	return r.Data(&terraform.InstanceState{
+		RawConfig: testRawObject(r, map[string]cty.Value{"name": cty.StringVal("server"), "wait_for_rollout": waitForRollout}),
+	})
+}
+
+func pod(name, status string, ready bool, restarts int32, errors ...string) rpaastypes.Pod {
+	p := rpaastypes.Pod{Name: name, Status: status, Ready: ready, Restarts: restarts}
+	for _, e := range errors {
+		p.Errors = append(p.Errors, rpaastypes.PodError{Message: e})
+	}
+	return p
+}
+
+func TestPodRevision(t *testing.T) {
+	assert.Equal(t, "5d8f7c9b4", podRevision(rpaastypes.Pod{Name: "my-rpaas-5d8f7c9b4-x2k9z"}))
+	assert.Equal(t, "7c6b5d4f8", podRevision(rpaastypes.Pod{Name: "rpaas-prod-web-7c6b5d4f8-abcde"}))
+	assert.Equal(t, "", podRevision(rpaastypes.Pod{Name: "my-rpaas"}))
+	assert.Equal(t, "", podRevision(rpaastypes.Pod{Name: "rpaas-x2k9z"}))
+}
+
+func TestWaitForRollout(t *testing.T) {
+	provider := &rpaasProvider{opts: &ProviderConfigOptions{}}
+
+	assert.True(t, waitForRollout(testRolloutResourceData(cty.True), provider))
+	assert.False(t, waitForRollout(testRolloutResourceData(cty.False), provider))
+	assert.False(t, waitForRollout(testRolloutResourceData(cty.NullVal(cty.Bool)), provider))
+
+	provider.opts.WaitForRollout = true
+	assert.True(t, waitForRollout(testRolloutResourceData(cty.NullVal(cty.Bool)), provider))
+	assert.False(t, waitForRollout(testRolloutResourceData(cty.False), provider))
+
+	// on deletion, there is only the state
+	r := resourceRpaasBlock()
+	deleted := r.Data(&terraform.InstanceState{
+		ID:       "rpaasv2::my-rpaas::server",
+		RawState: testRawObject(r, map[string]cty.Value{"name": cty.StringVal("server"), "wait_for_rollout": cty.False}),
+	})
+	assert.False(t, waitForRollout(deleted, provider))
+}
+
+func TestRolloutWaiter(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	provider, calls := newTestPodsServer(t,
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-aaaaa-2", "Running", true, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-aaaaa-2", "Running", true, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-aaaaa-2", "Terminating", true, 0), pod("my-rpaas-bbbbb-1", "Pending", false, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Terminating", true, 0), pod("my-rpaas-bbbbb-1", "Running", true, 0), pod("my-rpaas-bbbbb-2", "Running", false, 0, "Readiness probe failed")},
+		[]rpaastypes.Pod{pod("my-rpaas-bbbbb-1", "Running", true, 0), pod("my-rpaas-bbbbb-2", "Running", true, 0)},
+	)
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+	require.NotNil(t, waiter)
+
+	diags := waiter.Wait(ctx, time.Minute)
+	assert.Empty(t, diags)
+	assert.Equal(t, 5, calls())
+}
+
+func TestRolloutWaiter_Disabled(t *testing.T) {
+	provider, calls := newTestPodsServer(t, nil)
+
+	waiter, err := newRolloutWaiter(context.Background(), testRolloutResourceData(cty.False), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+	assert.Nil(t, waiter)
+	assert.Empty(t, waiter.Wait(context.Background(), time.Minute))
+	assert.Equal(t, 0, calls())
+}
+
+func TestRolloutWaiter_Crashlooping(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	provider, _ := newTestPodsServer(t,
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-bbbbb-1", "Errored", false, 3, "Back-off restarting failed container", `nginx: [emerg] unknown directive "proxy_pas"`)},
+	)
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+
+	diags := waiter.Wait(ctx, time.Minute)
+	require.Len(t, diags, 1)
+	assert.Equal(t, diag.Error, diags[0].Severity)
+	assert.Equal(t, "Pod my-rpaas-bbbbb-1 of instance my-rpaas is crashlooping after 3 restarts", diags[0].Summary)
+	assert.Equal(t, "Back-off restarting failed container\nnginx: [emerg] unknown directive \"proxy_pas\"", diags[0].Detail)
+}
+
+func TestRolloutWaiter_NoRollout(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	setTestRolloutGracePeriod(t, 20*time.Millisecond)
+
+	// the change rendered the same configuration
+	provider, calls := newTestPodsServer(t, []rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-aaaaa-2", "Running", true, 0)})
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+
+	diags := waiter.Wait(ctx, time.Minute)
+	assert.Empty(t, diags)
+	assert.Greater(t, calls(), 2)
+}
+
+func TestRolloutWaiter_NoRolloutNotReady(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+	setTestRolloutGracePeriod(t, time.Millisecond)
+
+	provider, _ := newTestPodsServer(t, []rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-aaaaa-2", "Running", false, 0)})
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+
+	diags := waiter.Wait(ctx, 50*time.Millisecond)
+	require.Len(t, diags, 1)
+	assert.Equal(t, diag.Error, diags[0].Severity)
+	assert.Equal(t, "Timeout waiting for the rollout of instance my-rpaas: no pod runs the new nginx configuration yet, 1 of 2 pods not ready", diags[0].Summary)
+}
+
+func TestRolloutWaiter_InfoWithoutLabels(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	// as served by the RPaaS API, which reports neither labels nor owners
+	bodies := []string{
+		`{"name":"my-rpaas","replicas":1,"pods":[
+			{"createdAt":"2024-05-02T10:00:00Z","name":"my-rpaas-5d8f7c9b4-x2k9z","ip":"10.0.0.1","host":"10.1.0.1","status":"Running","ports":[{"name":"http","hostPort":20001,"containerPort":8800,"protocol":"TCP"}],"restarts":0,"ready":true}]}`,
+		`{"name":"my-rpaas","replicas":1,"pods":[
+			{"createdAt":"2024-05-02T10:00:00Z","name":"my-rpaas-5d8f7c9b4-x2k9z","ip":"10.0.0.1","host":"10.1.0.1","status":"Terminating","restarts":0,"ready":true},
+			{"createdAt":"2024-05-02T10:05:00Z","name":"my-rpaas-6c9d8b7f5-q4w7e","ip":"10.0.0.2","host":"10.1.0.2","status":"Pending","restarts":0,"ready":false}]}`,
+		`{"name":"my-rpaas","replicas":1,"pods":[
+			{"createdAt":"2024-05-02T10:05:00Z","name":"my-rpaas-6c9d8b7f5-q4w7e","ip":"10.0.0.2","host":"10.1.0.2","status":"Running","restarts":0,"ready":true}]}`,
+	}
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/resources/my-rpaas/info", r.URL.Path)
+		w.Write([]byte(bodies[min(calls, len(bodies)-1)]))
+		calls++
+	}))
+	t.Cleanup(server.Close)
+	provider := &rpaasProvider{opts: &ProviderConfigOptions{URL: server.URL, Username: "admin", Password: "admin"}}
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+	require.NotNil(t, waiter)
+
+	diags := waiter.Wait(ctx, time.Minute)
+	assert.Empty(t, diags)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRolloutWaiter_NoPods(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	provider, calls := newTestPodsServer(t, []rpaastypes.Pod{})
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+
+	diags := waiter.Wait(ctx, 50*time.Millisecond)
+	assert.Empty(t, diags)
+	assert.Equal(t, 2, calls())
+}
+
+func TestRolloutWaiter_NoPodTemplateHash(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	setTestRolloutGracePeriod(t, 20*time.Millisecond)
+
+	// pods of unknown revision only have to be ready
+	provider, _ := newTestPodsServer(t,
+		[]rpaastypes.Pod{pod("my-rpaas", "Running", true, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas", "Pending", false, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas", "Running", true, 0)},
+	)
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+	require.NotNil(t, waiter)
+
+	diags := waiter.Wait(ctx, time.Minute)
+	assert.Empty(t, diags)
+}
+
+func TestRolloutWaiter_Timeout(t *testing.T) {
+	setTestRolloutPollInterval(t, time.Millisecond)
+
+	provider, _ := newTestPodsServer(t,
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0)},
+		[]rpaastypes.Pod{pod("my-rpaas-aaaaa-1", "Running", true, 0), pod("my-rpaas-bbbbb-1", "Pending", false, 0, "0/3 nodes are available: 3 Insufficient cpu.")},
+	)
+
+	ctx := context.Background()
+	waiter, err := newRolloutWaiter(ctx, testRolloutResourceData(cty.True), provider, "rpaasv2", "my-rpaas")
+	require.NoError(t, err)
+
+	diags := waiter.Wait(ctx, 50*time.Millisecond)
+	require.Len(t, diags, 1)
+	assert.Equal(t, diag.Error, diags[0].Severity)
+	assert.Equal(t, "Timeout waiting for the rollout of instance my-rpaas: 1 of 2 pods run the new nginx configuration, 0 of them ready, 0 terminating; pod my-rpaas-bbbbb-1 is Pending: 0/3 nodes are available: 3 Insufficient cpu.", diags[0].Summary)
+}
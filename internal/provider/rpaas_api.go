@@ -0,0 +1,387 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/autogenerated"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+// rpaasAPI is the client of a single RPaaS instance. Endpoints missing from
+// the autogenerated client (blocks, routes, certificates, ACLs, cert-manager
+// requests, files and metadata) are implemented here over the same HTTP
+// client, so that every call shares its transport and returns the HTTP
+// response.
+type rpaasAPI struct {
+	*autogenerated.APIClient
+
+	instance string
+}
+
+// apiError is returned when RPaaS API answers with an unexpected status.
+type apiError struct {
+	Status int
+	Body   string
+}
+
+func (e *apiError) Error() string {
+	status := fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	if e.Body != "" {
+		return fmt.Sprintf("unexpected status code: %s, detail: %s", status, e.Body)
+	}
+
+	return fmt.Sprintf("unexpected status code: %s", status)
+}
+
+func isNotFoundError(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// Info returns the instance info. Errors are returned as *apiError, like
+// the ones of the other calls.
+func (c *rpaasAPI) Info(ctx context.Context) (*autogenerated.InstanceInfo, *http.Response, error) {
+	info, response, err := c.RpaasApi.GetInstanceInfo(ctx, c.instance).Execute()
+	var openAPIErr *autogenerated.GenericOpenAPIError
+	if response != nil && errors.As(err, &openAPIErr) {
+		err = &apiError{Status: response.StatusCode, Body: string(bytes.TrimSpace(openAPIErr.Body()))}
+	}
+
+	return info, response, err
+}
+
+// Pods returns the pods of the instance, with the status fields missing
+// from the autogenerated instance info.
+func (c *rpaasAPI) Pods(ctx context.Context) ([]types.Pod, *http.Response, error) {
+	var info types.InstanceInfo
+	response, err := c.do(ctx, http.MethodGet, "/info", nil, nil, http.StatusOK, &info)
+	return info.Pods, response, err
+}
+
+func (c *rpaasAPI) ListBlocks(ctx context.Context) ([]types.Block, *http.Response, error) {
+	var result struct {
+		Blocks []types.Block `json:"blocks"`
+	}
+
+	response, err := c.do(ctx, http.MethodGet, "/block", nil, nil, http.StatusOK, &result)
+	return result.Blocks, response, err
+}
+
+func (c *rpaasAPI) UpdateBlock(ctx context.Context, block types.Block) (*http.Response, error) {
+	values := url.Values{}
+	values.Set("block_name", block.Name)
+	values.Set("content", block.Content)
+	if block.ServerName != "" {
+		values.Set("server_name", block.ServerName)
+	}
+	if block.Extend {
+		values.Set("extend", "true")
+	}
+
+	return c.do(ctx, http.MethodPost, "/block", nil, formBody(values), http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) DeleteBlock(ctx context.Context, serverName, name string) (*http.Response, error) {
+	var query url.Values
+	if serverName != "" {
+		query = url.Values{"server_name": []string{serverName}}
+	}
+
+	return c.do(ctx, http.MethodDelete, "/block/"+url.PathEscape(name), query, nil, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) ListRoutes(ctx context.Context) ([]types.Route, *http.Response, error) {
+	var result struct {
+		Routes []types.Route `json:"paths"`
+	}
+
+	response, err := c.do(ctx, http.MethodGet, "/route", nil, nil, http.StatusOK, &result)
+	return result.Routes, response, err
+}
+
+func (c *rpaasAPI) UpdateRoute(ctx context.Context, route types.Route) (*http.Response, error) {
+	values := url.Values{}
+	values.Set("path", route.Path)
+	if route.ServerName != "" {
+		values.Set("server_name", route.ServerName)
+	}
+	if route.Destination != "" {
+		values.Set("destination", route.Destination)
+	}
+	if route.HTTPSOnly {
+		values.Set("https_only", "true")
+	}
+	if route.Content != "" {
+		values.Set("content", route.Content)
+	}
+
+	return c.do(ctx, http.MethodPost, "/route", nil, formBody(values), http.StatusCreated, nil)
+}
+
+func (c *rpaasAPI) DeleteRoute(ctx context.Context, serverName, path string) (*http.Response, error) {
+	values := url.Values{}
+	values.Set("path", path)
+	if serverName != "" {
+		values.Set("server_name", serverName)
+	}
+
+	return c.do(ctx, http.MethodDelete, "/route", nil, formBody(values), http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) UpdateCertificate(ctx context.Context, name, certificate, key string) (*http.Response, error) {
+	body, err := multipartBody(func(w *multipart.Writer) error {
+		if err := writeFormFile(w, "cert", "cert.pem", []byte(certificate)); err != nil {
+			return err
+		}
+		if err := writeFormFile(w, "key", "key.pem", []byte(key)); err != nil {
+			return err
+		}
+		return w.WriteField("name", name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/certificate", nil, body, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) DeleteCertificate(ctx context.Context, name string) (*http.Response, error) {
+	return c.do(ctx, http.MethodDelete, "/certificate/"+url.PathEscape(name), nil, nil, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) ListAccessControlList(ctx context.Context) ([]types.AllowedUpstream, *http.Response, error) {
+	var acls []types.AllowedUpstream
+	response, err := c.do(ctx, http.MethodGet, "/acl", nil, nil, http.StatusOK, &acls)
+	return acls, response, err
+}
+
+func (c *rpaasAPI) AddAccessControlList(ctx context.Context, host string, port int) (*http.Response, error) {
+	body, err := jsonBody(types.AllowedUpstream{Host: host, Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/acl", nil, body, http.StatusCreated, nil)
+}
+
+func (c *rpaasAPI) RemoveAccessControlList(ctx context.Context, host string, port int) (*http.Response, error) {
+	body, err := jsonBody(types.AllowedUpstream{Host: host, Port: port})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodDelete, "/acl", nil, body, http.StatusNoContent, nil)
+}
+
+func (c *rpaasAPI) ListCertManagerRequests(ctx context.Context) ([]types.CertManager, *http.Response, error) {
+	var requests []types.CertManager
+	response, err := c.do(ctx, http.MethodGet, "/cert-manager", nil, nil, http.StatusOK, &requests)
+	return requests, response, err
+}
+
+func (c *rpaasAPI) UpdateCertManager(ctx context.Context, request types.CertManager) (*http.Response, error) {
+	body, err := jsonBody(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/cert-manager", nil, body, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) DeleteCertManagerByName(ctx context.Context, name string) (*http.Response, error) {
+	return c.do(ctx, http.MethodDelete, "/cert-manager", url.Values{"name": []string{name}}, nil, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) DeleteCertManagerByIssuer(ctx context.Context, issuer string) (*http.Response, error) {
+	return c.do(ctx, http.MethodDelete, "/cert-manager", url.Values{"issuer": []string{issuer}}, nil, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) ListExtraFiles(ctx context.Context) ([]types.RpaasFile, *http.Response, error) {
+	var files []types.RpaasFile
+	response, err := c.do(ctx, http.MethodGet, "/files", url.Values{"show-content": []string{"false"}}, nil, http.StatusOK, &files)
+	return files, response, err
+}
+
+func (c *rpaasAPI) GetExtraFile(ctx context.Context, name string) (types.RpaasFile, *http.Response, error) {
+	var file types.RpaasFile
+	response, err := c.do(ctx, http.MethodGet, "/files/"+url.PathEscape(name), nil, nil, http.StatusOK, &file)
+	return file, response, err
+}
+
+func (c *rpaasAPI) AddExtraFile(ctx context.Context, file types.RpaasFile) (*http.Response, error) {
+	body, err := extraFileBody(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/files", nil, body, http.StatusCreated, nil)
+}
+
+func (c *rpaasAPI) UpdateExtraFile(ctx context.Context, file types.RpaasFile) (*http.Response, error) {
+	body, err := extraFileBody(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPut, "/files", nil, body, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) DeleteExtraFile(ctx context.Context, name string) (*http.Response, error) {
+	body, err := jsonBody([]string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodDelete, "/files", nil, body, http.StatusOK, nil)
+}
+
+func (c *rpaasAPI) GetMetadata(ctx context.Context) (types.Metadata, *http.Response, error) {
+	var metadata types.Metadata
+	response, err := c.do(ctx, http.MethodGet, "/metadata", nil, nil, http.StatusOK, &metadata)
+	return metadata, response, err
+}
+
+// SetMetadata adds or replaces the labels and annotations given, keeping
+// the other ones.
+func (c *rpaasAPI) SetMetadata(ctx context.Context, metadata types.Metadata) (*http.Response, error) {
+	body, err := jsonBody(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, "/metadata", nil, body, http.StatusOK, nil)
+}
+
+// UnsetMetadata removes the labels and annotations named. It fails with not
+// found if any of them is missing.
+func (c *rpaasAPI) UnsetMetadata(ctx context.Context, metadata types.Metadata) (*http.Response, error) {
+	body, err := jsonBody(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodDelete, "/metadata", nil, body, http.StatusOK, nil)
+}
+
+// requestBody is a request payload along with its content type.
+type requestBody struct {
+	contentType string
+	content     []byte
+}
+
+func formBody(values url.Values) *requestBody {
+	return &requestBody{contentType: "application/x-www-form-urlencoded", content: []byte(values.Encode())}
+}
+
+func jsonBody(v interface{}) (*requestBody, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestBody{contentType: "application/json", content: content}, nil
+}
+
+func multipartBody(write func(w *multipart.Writer) error) (*requestBody, error) {
+	var buffer bytes.Buffer
+	w := multipart.NewWriter(&buffer)
+	if err := write(w); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &requestBody{contentType: w.FormDataContentType(), content: buffer.Bytes()}, nil
+}
+
+func writeFormFile(w *multipart.Writer, field, filename string, content []byte) error {
+	part, err := w.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = part.Write(content)
+	return err
+}
+
+func extraFileBody(file types.RpaasFile) (*requestBody, error) {
+	return multipartBody(func(w *multipart.Writer) error {
+		return writeFormFile(w, "files", filepath.Base(file.Name), file.Content)
+	})
+}
+
+// do sends a request to the path under the instance resource, e.g. "/block"
+// for "/resources/<instance>/block". The response body is read and left
+// readable again, so the response can be inspected by the caller as well.
+func (c *rpaasAPI) do(ctx context.Context, method, path string, query url.Values, body *requestBody, expectedStatus int, result interface{}) (*http.Response, error) {
+	cfg := c.GetConfig()
+
+	serverURL, err := cfg.Servers.URL(0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	u := fmt.Sprintf("%s/resources/%s%s", serverURL, url.PathEscape(c.instance), path)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var content io.Reader
+	if body != nil {
+		content = bytes.NewReader(body.content)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, u, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", body.contentType)
+	}
+	request.Header.Set("User-Agent", cfg.UserAgent)
+
+	if v := os.Getenv("RPAAS_DISABLE_VALIDATION"); v != "" {
+		request.Header.Set("X-Rpaas-Disable-Validation", v)
+	}
+
+	response, err := cfg.HTTPClient.Do(request)
+	if err != nil {
+		return response, err
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+	if err != nil {
+		return response, err
+	}
+
+	if response.StatusCode != expectedStatus {
+		return response, &apiError{Status: response.StatusCode, Body: string(bytes.TrimSpace(responseBody))}
+	}
+
+	if result != nil {
+		if err = json.Unmarshal(responseBody, result); err != nil {
+			return response, fmt.Errorf("Could not decode response from %s %s: %w", method, path, err)
+		}
+	}
+
+	return response, nil
+}
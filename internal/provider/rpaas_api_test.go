@@ -0,0 +1,224 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/rpaas-operator/pkg/rpaas/client/types"
+)
+
+func TestRpaasAPI(t *testing.T) {
+	server, provider := setupTestRpaasServer(t)
+	defer server.Stop()
+
+	ctx := context.Background()
+	client := provider.Client("rpaasv2", "my-rpaas")
+
+	t.Run("blocks", func(t *testing.T) {
+		_, err := client.UpdateBlock(ctx, types.Block{Name: "server", Content: "# my block"})
+		require.NoError(t, err)
+
+		blocks, response, err := client.ListBlocks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Contains(t, blocks, types.Block{Name: "server", Content: "# my block"})
+
+		_, err = client.DeleteBlock(ctx, "", "server")
+		require.NoError(t, err)
+
+		blocks, _, err = client.ListBlocks(ctx)
+		require.NoError(t, err)
+		assert.NotContains(t, blocks, types.Block{Name: "server", Content: "# my block"})
+	})
+
+	t.Run("routes", func(t *testing.T) {
+		_, err := client.UpdateRoute(ctx, types.Route{Path: "/app", Destination: "app.tsuru.io", HTTPSOnly: true})
+		require.NoError(t, err)
+
+		routes, _, err := client.ListRoutes(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Route{{Path: "/app", Destination: "app.tsuru.io", HTTPSOnly: true}}, routes)
+
+		_, err = client.DeleteRoute(ctx, "", "/app")
+		require.NoError(t, err)
+
+		routes, _, err = client.ListRoutes(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, routes)
+	})
+
+	t.Run("files", func(t *testing.T) {
+		_, err := client.AddExtraFile(ctx, types.RpaasFile{Name: "index.html", Content: []byte("Hello")})
+		require.NoError(t, err)
+
+		_, err = client.UpdateExtraFile(ctx, types.RpaasFile{Name: "index.html", Content: []byte("Hello world")})
+		require.NoError(t, err)
+
+		file, _, err := client.GetExtraFile(ctx, "index.html")
+		require.NoError(t, err)
+		assert.Equal(t, "Hello world", string(file.Content))
+
+		files, _, err := client.ListExtraFiles(ctx)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "index.html", files[0].Name)
+
+		_, err = client.DeleteExtraFile(ctx, "index.html")
+		require.NoError(t, err)
+
+		_, response, err := client.GetExtraFile(ctx, "index.html")
+		assert.True(t, isNotFoundError(err), "expected not found, got %v", err)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("cert-manager", func(t *testing.T) {
+		_, err := client.UpdateCertManager(ctx, types.CertManager{Issuer: "my-custom-issuer", DNSNames: []string{"my-rpaas.example.com"}})
+		require.NoError(t, err)
+
+		requests, _, err := client.ListCertManagerRequests(ctx)
+		require.NoError(t, err)
+		require.Len(t, requests, 1)
+		assert.Equal(t, "my-custom-issuer", requests[0].Issuer)
+		assert.Equal(t, []string{"my-rpaas.example.com"}, requests[0].DNSNames)
+
+		_, err = client.DeleteCertManagerByIssuer(ctx, "my-custom-issuer")
+		require.NoError(t, err)
+
+		requests, _, err = client.ListCertManagerRequests(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, requests)
+	})
+
+	t.Run("certificates", func(t *testing.T) {
+		certPEM, keyPEM, _ := generateTestClientCertificate(t)
+
+		_, err := client.UpdateCertificate(ctx, "my-cert", certPEM, keyPEM)
+		require.NoError(t, err)
+
+		info, _, err := client.Info(ctx)
+		require.NoError(t, err)
+		require.Len(t, info.GetCertificates(), 1)
+		assert.Equal(t, "my-cert", info.GetCertificates()[0].GetName())
+
+		_, err = client.DeleteCertificate(ctx, "my-cert")
+		require.NoError(t, err)
+
+		info, _, err = client.Info(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, info.GetCertificates())
+	})
+
+	t.Run("acls", func(t *testing.T) {
+		_, err := client.AddAccessControlList(ctx, "my-host.example.com", 443)
+		require.NoError(t, err)
+
+		acls, _, err := client.ListAccessControlList(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []types.AllowedUpstream{{Host: "my-host.example.com", Port: 443}}, acls)
+
+		_, err = client.RemoveAccessControlList(ctx, "my-host.example.com", 443)
+		require.NoError(t, err)
+
+		acls, _, err = client.ListAccessControlList(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, acls)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		_, err := client.SetMetadata(ctx, types.Metadata{
+			Labels:      []types.MetadataItem{{Name: "team", Value: "platform"}},
+			Annotations: []types.MetadataItem{{Name: "cost-center", Value: "1234"}},
+		})
+		require.NoError(t, err)
+
+		metadata, _, err := client.GetMetadata(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []types.MetadataItem{{Name: "team", Value: "platform"}}, metadata.Labels)
+		assert.Equal(t, []types.MetadataItem{{Name: "cost-center", Value: "1234"}}, metadata.Annotations)
+
+		_, err = client.UnsetMetadata(ctx, types.Metadata{Labels: []types.MetadataItem{{Name: "team"}}})
+		require.NoError(t, err)
+
+		metadata, _, err = client.GetMetadata(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, metadata.Labels)
+		assert.Len(t, metadata.Annotations, 1)
+
+		_, err = client.UnsetMetadata(ctx, types.Metadata{Labels: []types.MetadataItem{{Name: "team"}}})
+		assert.True(t, isNotFoundError(err), "expected not found, got %v", err)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		_, response, err := provider.Client("rpaasv2", "unknown-rpaas").ListBlocks(ctx)
+		assert.True(t, isNotFoundError(err), "expected not found, got %v", err)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		_, _, err = provider.Client("rpaasv2", "unknown-rpaas").Info(ctx)
+		assert.True(t, isNotFoundError(err), "expected not found, got %v", err)
+	})
+}
+
+func TestRpaasAPI_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/1.0/services/rpaasv2/proxy/my-rpaas", r.URL.Path)
+		assert.Equal(t, "/resources/my-rpaas/route", r.URL.Query().Get("callback"))
+
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("event locked\n"))
+	}))
+	defer server.Close()
+
+	provider := newTestClientPoolProvider(server.URL)
+
+	_, response, err := provider.Client("rpaasv2", "my-rpaas").ListRoutes(context.Background())
+	assert.EqualError(t, err, "unexpected status code: 409 Conflict, detail: event locked")
+	assert.False(t, isNotFoundError(err))
+	require.NotNil(t, response)
+
+	// the response is left readable to detect the locked events
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "event locked\n", string(body))
+}
+
+func TestRpaasAPI_PathEscaping(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	provider := &rpaasProvider{opts: &ProviderConfigOptions{URL: server.URL, Username: "admin", Password: "admin"}}
+	client := provider.Client("rpaasv2", "my-rpaas")
+	ctx := context.Background()
+
+	_, err := client.DeleteBlock(ctx, "", "my block")
+	require.NoError(t, err)
+
+	_, err = client.DeleteCertificate(ctx, "my cert")
+	require.NoError(t, err)
+
+	_, _, err = client.GetExtraFile(ctx, "my file?.html")
+	require.NoError(t, err)
+
+	_, _, err = provider.Client("rpaasv2", "my rpaas?").ListBlocks(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"DELETE /resources/my-rpaas/block/my%20block",
+		"DELETE /resources/my-rpaas/certificate/my%20cert",
+		"GET /resources/my-rpaas/files/my%20file%3F.html",
+		"GET /resources/my%20rpaas%3F/block",
+	}, paths)
+}
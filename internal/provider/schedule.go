@@ -0,0 +1,194 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	// scheduleParser parses the cron expressions of scheduled windows as the
+	// RPaaS API and KEDA do: the five standard fields, from minute to day of
+	// week, without seconds nor descriptors like "@daily".
+	scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+	// scheduleCheckPeriod is how far ahead the activations of the scheduled
+	// windows are checked, and maxScheduleIntervals how many activations of
+	// each window at most.
+	scheduleCheckPeriod  = 28 * 24 * time.Hour
+	maxScheduleIntervals = 1000
+
+	// scheduleNow returns the time the schedules are checked and previewed
+	// from.
+	scheduleNow = time.Now
+)
+
+// nextActivationsCount is how many activations next_activations previews.
+const nextActivationsCount = 5
+
+// scheduledWindow is a scheduled window of an autoscale, with its cron
+// expressions parsed.
+type scheduledWindow struct {
+	Index       int
+	MinReplicas int
+	Start       cron.Schedule
+	End         cron.Schedule
+}
+
+// windowInterval is an activation of a scheduled window. End is zero when
+// the window never ends.
+type windowInterval struct {
+	Window      int
+	MinReplicas int
+	Start       time.Time
+	End         time.Time
+}
+
+func validateCronExpression(value interface{}, path cty.Path) diag.Diagnostics {
+	v := value.(string)
+	if _, err := scheduleParser.Parse(v); err != nil {
+		detail := fmt.Sprintf("%v. Expected the five fields minute, hour, day of month, month and day of week, such as \"00 20 * * 1-5\".", err)
+		if len(strings.Fields(v)) == 6 {
+			// accepted at plan before, but always rejected by the RPaaS API
+			detail += " Six-field expressions are rejected by the RPaaS API as well: remove the extra field."
+		}
+
+		return diag.Diagnostics{{
+			Severity:      diag.Error,
+			Summary:       fmt.Sprintf("Invalid cron expression %q", v),
+			Detail:        detail,
+			AttributePath: path,
+		}}
+	}
+	return nil
+}
+
+func parseScheduledWindow(index, minReplicas int, start, end string) (scheduledWindow, error) {
+	startSchedule, err := scheduleParser.Parse(start)
+	if err != nil {
+		return scheduledWindow{}, fmt.Errorf("Invalid start cron expression %q: %w", start, err)
+	}
+
+	endSchedule, err := scheduleParser.Parse(end)
+	if err != nil {
+		return scheduledWindow{}, fmt.Errorf("Invalid end cron expression %q: %w", end, err)
+	}
+
+	return scheduledWindow{Index: index, MinReplicas: minReplicas, Start: startSchedule, End: endSchedule}, nil
+}
+
+// next returns the first activation of the window after the time given, if
+// the window starts again.
+func (w scheduledWindow) next(after time.Time) (windowInterval, bool) {
+	start := w.Start.Next(after)
+	if start.IsZero() {
+		return windowInterval{}, false
+	}
+
+	return windowInterval{Window: w.Index, MinReplicas: w.MinReplicas, Start: start, End: w.End.Next(start)}, true
+}
+
+// intervals returns the activations of the window starting until the time
+// given. Each one must end before the window starts again.
+func (w scheduledWindow) intervals(from, until time.Time) ([]windowInterval, error) {
+	var intervals []windowInterval
+	for t := from; len(intervals) < maxScheduleIntervals; {
+		interval, ok := w.next(t)
+		if !ok || interval.Start.After(until) {
+			break
+		}
+
+		if interval.End.IsZero() {
+			return nil, fmt.Errorf("\"scheduled_window.%d\" starts at %s but never ends: \"end\" must follow \"start\"", w.Index, formatScheduleTime(interval.Start))
+		}
+
+		if again, ok := w.next(interval.Start); ok && again.Start.Before(interval.End) {
+			return nil, fmt.Errorf("\"scheduled_window.%d\" starts at %s and again at %s, before ending at %s: \"end\" must follow \"start\"", w.Index, formatScheduleTime(interval.Start), formatScheduleTime(again.Start), formatScheduleTime(interval.End))
+		}
+
+		intervals = append(intervals, interval)
+		t = interval.Start
+	}
+
+	return intervals, nil
+}
+
+// checkScheduledWindows checks that each window ends before starting again,
+// and that no windows overlap, over the next scheduleCheckPeriod.
+func checkScheduledWindows(windows []scheduledWindow, now time.Time) error {
+	var intervals []windowInterval
+	for _, w := range windows {
+		wi, err := w.intervals(now, now.Add(scheduleCheckPeriod))
+		if err != nil {
+			return err
+		}
+		intervals = append(intervals, wi...)
+	}
+
+	sort.SliceStable(intervals, func(i, j int) bool { return intervals[i].Start.Before(intervals[j].Start) })
+
+	var latest *windowInterval
+	for i := range intervals {
+		interval := &intervals[i]
+		if latest != nil && interval.Window != latest.Window && interval.Start.Before(latest.End) {
+			first, second := latest.Window, interval.Window
+			if first > second {
+				first, second = second, first
+			}
+			return fmt.Errorf("\"scheduled_window.%d\" and \"scheduled_window.%d\" overlap from %s to %s", first, second, formatScheduleTime(interval.Start), formatScheduleTime(minTime(interval.End, latest.End)))
+		}
+
+		if latest == nil || interval.End.After(latest.End) {
+			latest = interval
+		}
+	}
+
+	return nil
+}
+
+// nextActivations returns the first activations of the windows after the
+// time given, sorted by start.
+func nextActivations(windows []scheduledWindow, now time.Time, count int) []windowInterval {
+	var activations []windowInterval
+	for _, w := range windows {
+		for t, i := now, 0; i < count; i++ {
+			interval, ok := w.next(t)
+			if !ok {
+				break
+			}
+			activations = append(activations, interval)
+			t = interval.Start
+		}
+	}
+
+	sort.SliceStable(activations, func(i, j int) bool { return activations[i].Start.Before(activations[j].Start) })
+	if len(activations) > count {
+		activations = activations[:count]
+	}
+
+	return activations
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWindow(t *testing.T, index, minReplicas int, start, end string) scheduledWindow {
+	t.Helper()
+	w, err := parseScheduledWindow(index, minReplicas, start, end)
+	require.NoError(t, err)
+	return w
+}
+
+func TestParseScheduledWindow(t *testing.T) {
+	_, err := parseScheduledWindow(0, 1, "00 20 * * 1-5", "00 00 * * 2-6")
+	assert.NoError(t, err)
+
+	_, err = parseScheduledWindow(0, 1, "00 00 20 * * 1-5", "00 00 * * 2-6")
+	assert.ErrorContains(t, err, `Invalid start cron expression "00 00 20 * * 1-5"`)
+
+	_, err = parseScheduledWindow(0, 1, "00 20 * * 1-5", "@daily")
+	assert.ErrorContains(t, err, `Invalid end cron expression "@daily"`)
+
+	assert.Empty(t, validateCronExpression("*/15 8-18 1,15 * MON-FRI", nil))
+	diags := validateCronExpression("00 20 * * * 1-5", nil)
+	require.Len(t, diags, 1)
+	assert.Equal(t, `Invalid cron expression "00 20 * * * 1-5"`, diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, "Six-field expressions are rejected by the RPaaS API as well: remove the extra field.")
+
+	diags = validateCronExpression("00 20 * * MON-", nil)
+	require.Len(t, diags, 1)
+	assert.NotContains(t, diags[0].Detail, "Six-field")
+}
+
+func TestCheckScheduledWindows(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+
+	weekdays := testWindow(t, 0, 5, "00 08 * * 1-5", "00 20 * * 1-5")
+	weekend := testWindow(t, 1, 2, "00 10 * * 6", "00 22 * * 0")
+	assert.NoError(t, checkScheduledWindows([]scheduledWindow{weekdays, weekend}, now))
+
+	// over midnight
+	nights := testWindow(t, 0, 5, "00 22 * * *", "00 02 * * *")
+	assert.NoError(t, checkScheduledWindows([]scheduledWindow{nights}, now))
+
+	err := checkScheduledWindows([]scheduledWindow{weekdays, testWindow(t, 1, 8, "00 19 * * 5", "00 01 * * 6")}, now)
+	assert.EqualError(t, err, `"scheduled_window.0" and "scheduled_window.1" overlap from 2024-05-17T19:00:00Z to 2024-05-17T20:00:00Z`)
+
+	err = checkScheduledWindows([]scheduledWindow{testWindow(t, 0, 5, "00 08 * * *", "00 20 * * 1")}, now)
+	assert.EqualError(t, err, `"scheduled_window.0" starts at 2024-05-16T08:00:00Z and again at 2024-05-17T08:00:00Z, before ending at 2024-05-20T20:00:00Z: "end" must follow "start"`)
+
+	err = checkScheduledWindows([]scheduledWindow{testWindow(t, 0, 5, "00 08 * * *", "00 20 30 2 *")}, now)
+	assert.EqualError(t, err, `"scheduled_window.0" starts at 2024-05-16T08:00:00Z but never ends: "end" must follow "start"`)
+}
+
+func TestNextActivations(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+
+	weekdays := testWindow(t, 0, 5, "00 08 * * 1-5", "00 20 * * 1-5")
+	weekend := testWindow(t, 1, 2, "00 10 * * 6", "00 22 * * 0")
+
+	activations := nextActivations([]scheduledWindow{weekdays, weekend}, now, 4)
+	assert.Equal(t, []windowInterval{
+		{Window: 0, MinReplicas: 5, Start: time.Date(2024, 5, 16, 8, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 16, 20, 0, 0, 0, time.UTC)},
+		{Window: 0, MinReplicas: 5, Start: time.Date(2024, 5, 17, 8, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 17, 20, 0, 0, 0, time.UTC)},
+		{Window: 1, MinReplicas: 2, Start: time.Date(2024, 5, 18, 10, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 19, 22, 0, 0, 0, time.UTC)},
+		{Window: 0, MinReplicas: 5, Start: time.Date(2024, 5, 20, 8, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 20, 20, 0, 0, 0, time.UTC)},
+	}, activations)
+
+	assert.Empty(t, nextActivations(nil, now, 4))
+}
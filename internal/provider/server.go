@@ -0,0 +1,41 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+
+	fwprovider "github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-mux/tf5to6server"
+	"github.com/hashicorp/terraform-plugin-mux/tf6muxserver"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// ProviderServer returns the protocol v6 server of the provider, muxing the
+// SDKv2 resources with the ones written with the plugin framework.
+func ProviderServer(ctx context.Context) (func() tfprotov6.ProviderServer, error) {
+	configured := &configuredProvider{}
+	sdk := newProvider(configured)
+	return newProviderServer(ctx, sdk, newFrameworkProvider(sdk, configured))
+}
+
+func newProviderServer(ctx context.Context, sdk *schema.Provider, framework fwprovider.Provider) (func() tfprotov6.ProviderServer, error) {
+	upgraded, err := tf5to6server.UpgradeServer(ctx, sdk.GRPCProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	mux, err := tf6muxserver.NewMuxServer(ctx,
+		func() tfprotov6.ProviderServer { return upgraded },
+		providerserver.NewProtocol6(framework),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mux.ProviderServer, nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fwprovider "github.com/hashicorp/terraform-plugin-framework/provider"
+	fwschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	rschema "github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFrameworkProvider adds a resource to the framework provider, which
+// keeps the provider data it is configured with.
+type testFrameworkProvider struct {
+	*frameworkProvider
+
+	resource *testFrameworkResource
+}
+
+func (p *testFrameworkProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return []func() resource.Resource{func() resource.Resource { return p.resource }}
+}
+
+type testFrameworkResource struct {
+	providerData interface{}
+}
+
+func (r *testFrameworkResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_framework_test"
+}
+
+func (r *testFrameworkResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = rschema.Schema{Attributes: map[string]rschema.Attribute{
+		"id": rschema.StringAttribute{Computed: true},
+	}}
+}
+
+func (r *testFrameworkResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData != nil {
+		r.providerData = req.ProviderData
+	}
+}
+
+func (r *testFrameworkResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+}
+
+func (r *testFrameworkResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	resp.State = req.State
+}
+
+func (r *testFrameworkResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+}
+
+func (r *testFrameworkResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+}
+
+func TestProviderServer(t *testing.T) {
+	ctx := context.Background()
+
+	providerServer, err := ProviderServer(ctx)
+	require.NoError(t, err)
+
+	providerSchema, err := providerServer().GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, providerSchema.Diagnostics)
+
+	for name := range Provider().ResourcesMap {
+		assert.Contains(t, providerSchema.ResourceSchemas, name)
+	}
+
+	// nested attributes are only available on protocol v6
+	require.Contains(t, providerSchema.DataSourceSchemas, "rpaas_instance_status")
+	var pods *tfprotov6.SchemaAttribute
+	for _, a := range providerSchema.DataSourceSchemas["rpaas_instance_status"].Block.Attributes {
+		if a.Name == "pods" {
+			pods = a
+		}
+	}
+	require.NotNil(t, pods)
+	require.NotNil(t, pods.NestedType)
+	assert.Equal(t, tfprotov6.SchemaObjectNestingModeList, pods.NestedType.Nesting)
+}
+
+func TestProviderServer_BothHalvesShareConfiguration(t *testing.T) {
+	for _, env := range []string{"RPAAS_USER", "RPAAS_PASSWORD", "TSURU_TARGET", "TSURU_TOKEN", "RPAAS_MAX_REQUESTS_PER_SECOND", "RPAAS_MAX_CONCURRENT_REQUESTS"} {
+		t.Setenv(env, "")
+	}
+
+	ctx := context.Background()
+	holder := &configuredProvider{}
+	sdk := newProvider(holder)
+	framework := &testFrameworkProvider{frameworkProvider: newFrameworkProvider(sdk, holder), resource: &testFrameworkResource{}}
+
+	providerServer, err := newProviderServer(ctx, sdk, framework)
+	require.NoError(t, err)
+	server := providerServer()
+
+	providerSchema, err := server.GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	require.NoError(t, err)
+	require.Empty(t, providerSchema.Diagnostics)
+	assert.Contains(t, providerSchema.ResourceSchemas, "rpaas_route")
+	assert.Contains(t, providerSchema.ResourceSchemas, "rpaas_framework_test")
+
+	configType := providerSchema.Provider.ValueType().(tftypes.Object)
+	configValues := make(map[string]tftypes.Value)
+	for name, attributeType := range configType.AttributeTypes {
+		configValues[name] = tftypes.NewValue(attributeType, nil)
+	}
+	configValues["rpaas_url"] = tftypes.NewValue(tftypes.String, "http://rpaas.example.com")
+
+	config, err := tfprotov6.NewDynamicValue(configType, tftypes.NewValue(configType, configValues))
+	require.NoError(t, err)
+
+	configured, err := server.ConfigureProvider(ctx, &tfprotov6.ConfigureProviderRequest{Config: &config})
+	require.NoError(t, err)
+	require.Empty(t, configured.Diagnostics)
+
+	stateType := providerSchema.ResourceSchemas["rpaas_framework_test"].ValueType()
+	state, err := tfprotov6.NewDynamicValue(stateType, tftypes.NewValue(stateType, map[string]tftypes.Value{
+		"id": tftypes.NewValue(tftypes.String, "my-id"),
+	}))
+	require.NoError(t, err)
+
+	read, err := server.ReadResource(ctx, &tfprotov6.ReadResourceRequest{TypeName: "rpaas_framework_test", CurrentState: &state})
+	require.NoError(t, err)
+	require.Empty(t, read.Diagnostics)
+
+	// the framework resource gets the RPaaS provider configured by the SDKv2 half
+	provider, ok := framework.resource.providerData.(*rpaasProvider)
+	require.True(t, ok, "unexpected provider data: %#v", framework.resource.providerData)
+	assert.Same(t, sdk.Meta(), provider)
+	assert.Equal(t, "http://rpaas.example.com", provider.opts.URL)
+}
+
+func TestFrameworkProvider_ConfiguredFirst(t *testing.T) {
+	for _, env := range []string{"RPAAS_USER", "RPAAS_PASSWORD", "TSURU_TARGET", "TSURU_TOKEN", "RPAAS_MAX_REQUESTS_PER_SECOND"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("RPAAS_WAIT_FOR_ROLLOUT", "true")
+	t.Setenv("RPAAS_MAX_CONCURRENT_REQUESTS", "4")
+
+	ctx := context.Background()
+	configured := &configuredProvider{}
+	sdk := newProvider(configured)
+	framework := newFrameworkProvider(sdk, configured)
+
+	attributes, err := frameworkProviderAttributes(sdk.Schema)
+	require.NoError(t, err)
+	configType := fwschema.Schema{Attributes: attributes}.Type().TerraformType(ctx).(tftypes.Object)
+	configValues := make(map[string]tftypes.Value)
+	for name, attributeType := range configType.AttributeTypes {
+		configValues[name] = tftypes.NewValue(attributeType, nil)
+	}
+	configValues["rpaas_url"] = tftypes.NewValue(tftypes.String, "http://rpaas.example.com")
+	configValues["http_timeout_in_seconds"] = tftypes.NewValue(tftypes.Number, 30)
+	configValues["max_requests_per_second"] = tftypes.NewValue(tftypes.Number, 2.5)
+	configValues["skip_cert_verification"] = tftypes.NewValue(tftypes.Bool, false)
+	configValues["default_instance"] = tftypes.NewValue(tftypes.String, tftypes.UnknownValue)
+
+	var resp fwprovider.ConfigureResponse
+	framework.Configure(ctx, fwprovider.ConfigureRequest{Config: tfsdk.Config{Raw: tftypes.NewValue(configType, configValues)}}, &resp)
+	require.Empty(t, resp.Diagnostics)
+
+	provider, ok := resp.ResourceData.(*rpaasProvider)
+	require.True(t, ok, "unexpected provider data: %#v", resp.ResourceData)
+	assert.Same(t, provider, resp.DataSourceData)
+	assert.Equal(t, "http://rpaas.example.com", provider.opts.URL)
+	assert.Equal(t, 30*time.Second, provider.opts.Timeout)
+	assert.Equal(t, 2.5, provider.opts.MaxRequestsPerSecond)
+	assert.Equal(t, 4, provider.opts.MaxConcurrentRequests)
+	assert.True(t, provider.opts.WaitForRollout)
+	assert.False(t, provider.opts.InsecureSkipVerify)
+	assert.Empty(t, provider.opts.DefaultInstance)
+
+	// the SDKv2 half, configured afterwards, reuses the same RPaaS provider
+	diags := sdk.Configure(ctx, terraform.NewResourceConfigRaw(map[string]interface{}{
+		"rpaas_url": "http://rpaas.example.com",
+	}))
+	require.False(t, diags.HasError(), "unexpected diagnostics: %#v", diags)
+	assert.Same(t, provider, sdk.Meta())
+}
+
+func TestNewFrameworkProviderConfig_InvalidDefault(t *testing.T) {
+	t.Setenv("SKIP_CERT_VERIFICATION", "maybe")
+
+	_, err := newFrameworkProviderConfig(Provider().Schema, tftypes.Value{})
+	assert.ErrorContains(t, err, `Could not read provider attribute "skip_cert_verification"`)
+}
+
+func TestFrameworkProviderAttributes(t *testing.T) {
+	attributes, err := frameworkProviderAttributes(Provider().Schema)
+	require.NoError(t, err)
+	assert.Len(t, attributes, len(Provider().Schema))
+
+	_, err = frameworkProviderAttributes(map[string]*schema.Schema{
+		"headers": {Type: schema.TypeMap, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+	})
+	assert.EqualError(t, err, `Could not convert provider attribute "headers": unsupported type TypeMap`)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// rootTemplateName is the name of the main template, which an include must
+// not reuse or it would silently replace it.
+const rootTemplateName = "template"
+
+// renderTemplate renders a Go text/template using vars as its data. Each
+// entry of includes is parsed as a named template, so it can be used from the
+// main template with {{ template "name" . }}. Referencing a variable that is
+// not present in vars is an error rather than an empty string.
+func renderTemplate(text string, includes, vars map[string]interface{}) (string, error) {
+	tmpl := template.New(rootTemplateName).Option("missingkey=error")
+
+	names := make([]string, 0, len(includes))
+	for name := range includes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == rootTemplateName {
+			return "", fmt.Errorf("include name %q is reserved for the main template", name)
+		}
+		if _, err := tmpl.New(name).Parse(includes[name].(string)); err != nil {
+			return "", fmt.Errorf("could not parse include %q: %w", name, err)
+		}
+	}
+
+	if _, err := tmpl.Parse(text); err != nil {
+		return "", fmt.Errorf("could not parse template: %w", err)
+	}
+
+	data := make(map[string]string, len(vars))
+	for k, v := range vars {
+		data[k] = v.(string)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("could not render template: %w", err)
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name          string
+		template      string
+		includes      map[string]interface{}
+		vars          map[string]interface{}
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "plain content",
+			template: "# nginx config\n",
+			expected: "# nginx config\n",
+		},
+		{
+			name:     "with vars",
+			template: "proxy_pass http://{{ .upstream }}:{{ .port }};\n",
+			vars:     map[string]interface{}{"upstream": "app.tsuru.io", "port": "8080"},
+			expected: "proxy_pass http://app.tsuru.io:8080;\n",
+		},
+		{
+			name:     "with includes",
+			template: "location / {\n{{ template \"headers\" . }}}\n",
+			includes: map[string]interface{}{"headers": "  more_set_headers 'X-Env: {{ .env }}';\n"},
+			vars:     map[string]interface{}{"env": "prod"},
+			expected: "location / {\n  more_set_headers 'X-Env: prod';\n}\n",
+		},
+		{
+			name:          "missing var",
+			template:      "{{ .missing }}",
+			expectedError: `could not render template: template: template:1:3: executing "template" at <.missing>: map has no entry for key "missing"`,
+		},
+		{
+			name:          "invalid template",
+			template:      "{{ .upstream",
+			expectedError: "could not parse template: template: template:1: unclosed action",
+		},
+		{
+			name:          "invalid include",
+			template:      "",
+			includes:      map[string]interface{}{"broken": "{{ if }}"},
+			expectedError: `could not parse include "broken": template: broken:1: missing value for if`,
+		},
+		{
+			name:          "include named after the main template",
+			template:      "# nginx config\n",
+			includes:      map[string]interface{}{"template": "# replaced\n"},
+			expectedError: `include name "template" is reserved for the main template`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := renderTemplate(tt.template, tt.includes, tt.vars)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, rendered)
+		})
+	}
+}
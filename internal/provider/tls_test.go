@@ -0,0 +1,191 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestClientTLS checks the TLS settings on every kind of request: those of
+// the autogenerated client and those of rpaasAPI, either straight to RPaaS
+// or through the Tsuru API.
+func TestClientTLS(t *testing.T) {
+	clientCertPEM, clientKeyPEM, clientCAs := generateTestClientCertificate(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	})
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	mtlsServer := httptest.NewUnstartedServer(handler)
+	mtlsServer.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	mtlsServer.StartTLS()
+	defer mtlsServer.Close()
+
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caCertFile, certificatePEM(server.Certificate()), 0600))
+
+	tests := []struct {
+		name          string
+		url           string
+		config        map[string]interface{}
+		expectedError string
+	}{
+		{
+			name:          "unknown CA",
+			url:           server.URL,
+			expectedError: "certificate signed by unknown authority",
+		},
+		{
+			name:   "skip verification",
+			url:    server.URL,
+			config: map[string]interface{}{"skip_cert_verification": true},
+		},
+		{
+			name:   "ca_cert_pem",
+			url:    server.URL,
+			config: map[string]interface{}{"ca_cert_pem": string(certificatePEM(server.Certificate()))},
+		},
+		{
+			name:   "ca_cert_file",
+			url:    server.URL,
+			config: map[string]interface{}{"ca_cert_file": caCertFile},
+		},
+		{
+			name:          "mutual TLS without client certificate",
+			url:           mtlsServer.URL,
+			config:        map[string]interface{}{"ca_cert_pem": string(certificatePEM(mtlsServer.Certificate()))},
+			expectedError: "certificate required",
+		},
+		{
+			name: "mutual TLS",
+			url:  mtlsServer.URL,
+			config: map[string]interface{}{
+				"ca_cert_pem":     string(certificatePEM(mtlsServer.Certificate())),
+				"client_cert_pem": clientCertPEM,
+				"client_key_pem":  clientKeyPEM,
+			},
+		},
+		{
+			name: "mutual TLS through the Tsuru API",
+			config: map[string]interface{}{
+				"tsuru_target":    mtlsServer.URL,
+				"tsuru_token":     "my-token",
+				"ca_cert_pem":     string(certificatePEM(mtlsServer.Certificate())),
+				"client_cert_pem": clientCertPEM,
+				"client_key_pem":  clientKeyPEM,
+			},
+		},
+		{
+			name: "mutual TLS through the Tsuru API without client certificate",
+			config: map[string]interface{}{
+				"tsuru_target": mtlsServer.URL,
+				"tsuru_token":  "my-token",
+				"ca_cert_pem":  string(certificatePEM(mtlsServer.Certificate())),
+			},
+			expectedError: "certificate required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range []string{"RPAAS_URL", "RPAAS_CA_CERT_FILE", "SKIP_CERT_VERIFICATION", "TSURU_TARGET", "TSURU_TOKEN"} {
+				t.Setenv(env, "")
+			}
+
+			raw := map[string]interface{}{}
+			if tt.url != "" {
+				raw["rpaas_url"] = tt.url
+			}
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			opts, err := getProviderConfigOpts(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, raw))
+			require.NoError(t, err)
+
+			client := (&rpaasProvider{opts: opts}).Client("rpaasv2", "my-rpaas")
+			autoscale, _, err := client.RpaasApi.GetAutoscale(context.Background(), "my-rpaas").Execute()
+			_, _, apiErr := client.ListRoutes(context.Background())
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				assert.ErrorContains(t, apiErr, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, int32(2), autoscale.MaxReplicas)
+			assert.NoError(t, apiErr)
+		})
+	}
+}
+
+func TestBuildTLSConfig_InvalidCertificates(t *testing.T) {
+	_, err := buildTLSConfig(&ProviderConfigOptions{CACertPEM: "not a certificate"})
+	assert.EqualError(t, err, "Could not load CA certificates: no PEM encoded certificate found")
+
+	_, err = buildTLSConfig(&ProviderConfigOptions{ClientCertPEM: "not a certificate", ClientKeyPEM: "not a key"})
+	assert.ErrorContains(t, err, "Could not load client certificate")
+}
+
+func generateTestClientCertificate(t *testing.T) (certPEM, keyPEM string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "terraform-provider-rpaas"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return string(certificatePEM(cert)), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})), pool
+}
+
+func certificatePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
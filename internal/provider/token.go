@@ -0,0 +1,194 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenCommandTimeout = 30 * time.Second
+
+	// tokens expiring within this window are acquired again before use
+	tokenExpiryDelta = time.Minute
+)
+
+// tsuruTokenSource caches the Tsuru token returned by fetch, acquiring a new
+// one when the cached token is about to expire (for JWT tokens) or after it
+// is rejected by the API.
+type tsuruTokenSource struct {
+	fetch func(ctx context.Context) (string, error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newTsuruTokenSource(fetch func(ctx context.Context) (string, error)) *tsuruTokenSource {
+	return &tsuruTokenSource{fetch: fetch}
+}
+
+// tokenCommandFetcher runs the command through the shell and uses its
+// standard output, without surrounding whitespace, as the token.
+func tokenCommandFetcher(command string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, tokenCommandTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("Could not run tsuru_token_command: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		token := strings.TrimSpace(stdout.String())
+		if token == "" {
+			return "", fmt.Errorf("Could not run tsuru_token_command: empty output")
+		}
+
+		return token, nil
+	}
+}
+
+// tokenFileFetcher reads the token from the file every time, so an external
+// agent may rotate it.
+func tokenFileFetcher(path string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Could not read tsuru_token_file: %w", err)
+		}
+
+		token := strings.TrimSpace(string(content))
+		if token == "" {
+			return "", fmt.Errorf("Could not read tsuru_token_file: %s is empty", path)
+		}
+
+		return token, nil
+	}
+}
+
+func (s *tsuruTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Until(s.expiry) > tokenExpiryDelta) {
+		return s.token, nil
+	}
+
+	token, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if token == "" {
+		return "", fmt.Errorf("Tsuru token not found: set tsuru_token, tsuru_token_command or tsuru_token_file")
+	}
+
+	s.token, s.expiry = token, tokenExpiry(token)
+	return s.token, nil
+}
+
+// Invalidate discards token, if still cached, so the next call to Token
+// acquires a new one.
+func (s *tsuruTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token, s.expiry = "", time.Time{}
+	}
+}
+
+// tokenExpiry returns the "exp" claim of JWT tokens, without verifying them,
+// or the zero time for any other token.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
+
+var _ http.RoundTripper = (*tsuruTokenTransport)(nil)
+
+// tsuruTokenTransport sets the current token on requests to Tsuru API. When
+// the API answers 401 the token is acquired again and the request is retried
+// once.
+type tsuruTokenTransport struct {
+	Source *tsuruTokenSource
+	Base   http.RoundTripper
+}
+
+func (t *tsuruTokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.Base.RoundTrip(t.withToken(r, token))
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	if r.Body != nil && r.GetBody == nil {
+		return response, nil
+	}
+
+	t.Source.Invalidate(token)
+	newToken, err := t.Source.Token(r.Context())
+	if err == nil && newToken == token {
+		return response, nil
+	}
+
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	retry := t.withToken(r, newToken)
+	if r.GetBody != nil {
+		if retry.Body, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.Base.RoundTrip(retry)
+}
+
+func (t *tsuruTokenTransport) withToken(r *http.Request, token string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return r2
+}
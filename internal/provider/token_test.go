@@ -0,0 +1,253 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCommandFetcher(t *testing.T) {
+	token, err := tokenCommandFetcher("echo '  my-token  '")(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "my-token", token)
+
+	_, err = tokenCommandFetcher("echo 'not logged in' >&2; exit 1")(context.Background())
+	assert.EqualError(t, err, "Could not run tsuru_token_command: exit status 1: not logged in")
+
+	_, err = tokenCommandFetcher("true")(context.Background())
+	assert.EqualError(t, err, "Could not run tsuru_token_command: empty output")
+}
+
+func TestTsuruTokenSource(t *testing.T) {
+	var tokens []string
+	var calls int
+	source := newTsuruTokenSource(func(ctx context.Context) (string, error) {
+		calls++
+		return tokens[calls-1], nil
+	})
+
+	tokens = []string{"token-1", testJWT(time.Now().Add(30 * time.Second)), testJWT(time.Now().Add(time.Hour))}
+
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+
+	// opaque tokens are kept until invalidated
+	token, _ = source.Token(context.Background())
+	assert.Equal(t, "token-1", token)
+	assert.Equal(t, 1, calls)
+
+	source.Invalidate("another-token")
+	token, _ = source.Token(context.Background())
+	assert.Equal(t, "token-1", token)
+
+	source.Invalidate("token-1")
+	token, _ = source.Token(context.Background())
+	assert.Equal(t, tokens[1], token)
+	assert.Equal(t, 2, calls)
+
+	// about to expire
+	token, _ = source.Token(context.Background())
+	assert.Equal(t, tokens[2], token)
+	assert.Equal(t, 3, calls)
+
+	token, _ = source.Token(context.Background())
+	assert.Equal(t, tokens[2], token)
+	assert.Equal(t, 3, calls)
+}
+
+func TestTsuruTokenSource_emptyToken(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &tsuruTokenTransport{
+			Source: newTsuruTokenSource(func(ctx context.Context) (string, error) {
+				return "", nil
+			}),
+			Base: http.DefaultTransport,
+		},
+	}
+
+	_, err := client.Get(server.URL)
+	assert.ErrorContains(t, err, "Tsuru token not found: set tsuru_token, tsuru_token_command or tsuru_token_file")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+}
+
+func TestTsuruTokenTransport(t *testing.T) {
+	var validToken atomic.Value
+	validToken.Store("token-1")
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "Bearer "+validToken.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token-1\n"), 0600))
+
+	client := &http.Client{
+		Transport: &tsuruTokenTransport{
+			Source: newTsuruTokenSource(tokenFileFetcher(tokenFile)),
+			Base:   http.DefaultTransport,
+		},
+	}
+
+	post := func() (*http.Response, string) {
+		response, err := client.Post(server.URL, "text/plain", strings.NewReader("my body"))
+		require.NoError(t, err)
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response, string(body)
+	}
+
+	response, body := post()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "POST my body", body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// the token is rotated: the request is retried once with the new token
+	validToken.Store("token-2")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token-2\n"), 0600))
+
+	response, body = post()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "POST my body", body)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// the new token is rejected as well: no further retries
+	validToken.Store("token-3")
+
+	response, _ = post()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+}
+
+func TestAutogeneratedClientTokenRefresh(t *testing.T) {
+	var validToken atomic.Value
+	validToken.Store("token-1")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1.0/services/rpaasv2/proxy/my-rpaas" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+validToken.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	}))
+	defer server.Close()
+
+	for _, env := range []string{"RPAAS_URL", "TSURU_TARGET", "TSURU_TOKEN", "TSURU_TOKEN_COMMAND", "TSURU_TOKEN_FILE", "TSURU_CONFIG_DIR"} {
+		t.Setenv(env, "")
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token-1"), 0600))
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"tsuru_target":     server.URL,
+		"tsuru_token_file": tokenFile,
+	})
+	opts, err := getProviderConfigOpts(context.Background(), d)
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", opts.TsuruToken)
+
+	provider := &rpaasProvider{opts: opts}
+
+	_, _, err = provider.Client("rpaasv2", "my-rpaas").RpaasApi.GetAutoscale(context.Background(), "my-rpaas").Execute()
+	require.NoError(t, err)
+
+	validToken.Store("token-2")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token-2"), 0600))
+
+	_, _, err = provider.Client("rpaasv2", "my-rpaas").RpaasApi.GetAutoscale(context.Background(), "my-rpaas").Execute()
+	require.NoError(t, err)
+}
+
+func TestGetProviderConfigOpts_TsuruTokenPrecedence(t *testing.T) {
+	for _, env := range []string{"RPAAS_URL", "TSURU_TARGET", "TSURU_TOKEN", "TSURU_TOKEN_COMMAND", "TSURU_TOKEN_FILE", "TSURU_CONFIG_DIR"} {
+		t.Setenv(env, "")
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeTestFiles(t, filepath.Join(home, ".tsuru"), map[string]string{"target": "https://tsuru.example.com", "token": "config-token"})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("file-token"), 0600))
+
+	tests := []struct {
+		config   map[string]interface{}
+		expected string
+	}{
+		{
+			config:   map[string]interface{}{"tsuru_token": "my-token", "tsuru_token_command": "echo command-token", "tsuru_token_file": tokenFile},
+			expected: "my-token",
+		},
+		{
+			config:   map[string]interface{}{"tsuru_token_command": "echo command-token", "tsuru_token_file": tokenFile},
+			expected: "command-token",
+		},
+		{
+			config:   map[string]interface{}{"tsuru_token_file": tokenFile},
+			expected: "file-token",
+		},
+		{
+			config:   map[string]interface{}{},
+			expected: "config-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			tt.config["tsuru_target"] = "https://tsuru.example.com"
+
+			opts, err := getProviderConfigOpts(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, tt.config))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, opts.TsuruToken)
+		})
+	}
+}
+
+func testJWT(expiry time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return strings.Join([]string{
+		encode([]byte(`{"alg":"none"}`)),
+		encode([]byte(fmt.Sprintf(`{"sub":"me","exp":%d}`, expiry.Unix()))),
+		"signature",
+	}, ".")
+}
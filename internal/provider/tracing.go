@@ -0,0 +1,212 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName = "github.com/tsuru/terraform-provider-rpaas"
+
+	// tracingShutdownTimeout bounds the export of the remaining spans when the
+	// provider stops, as Terraform kills providers that take more than two
+	// seconds to stop.
+	tracingShutdownTimeout = 1500 * time.Millisecond
+)
+
+// sdkTracerProviders are the tracer providers exporting spans, shut down by
+// ShutdownTracing.
+var (
+	sdkTracerProvidersMu sync.Mutex
+	sdkTracerProviders   []*sdktrace.TracerProvider
+)
+
+// newTracerProvider exports spans through OTLP over HTTP when the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)
+// environment variable is set. Otherwise tracing is disabled.
+func newTracerProvider(ctx context.Context) (trace.TracerProvider, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop.NewTracerProvider(), nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create OpenTelemetry exporter: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("terraform-provider-rpaas"),
+			semconv.ServiceVersion(Version),
+		)),
+	)
+
+	sdkTracerProvidersMu.Lock()
+	sdkTracerProviders = append(sdkTracerProviders, tp)
+	sdkTracerProvidersMu.Unlock()
+
+	return tp, nil
+}
+
+// ShutdownTracing exports the spans not exported yet, once the provider
+// server stops. Spans are batched until then, so that exporting them does
+// not slow down operations.
+func ShutdownTracing(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, tracingShutdownTimeout)
+	defer cancel()
+
+	sdkTracerProvidersMu.Lock()
+	tps := sdkTracerProviders
+	sdkTracerProviders = nil
+	sdkTracerProvidersMu.Unlock()
+
+	for _, tp := range tps {
+		if err := tp.Shutdown(ctx); err != nil {
+			tflog.Warn(ctx, "Could not export OpenTelemetry spans", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+func tracerProvider(opts *ProviderConfigOptions) trace.TracerProvider {
+	if opts == nil || opts.tracerProvider == nil {
+		return noop.NewTracerProvider()
+	}
+
+	return opts.tracerProvider
+}
+
+// startOperationSpan starts the span of a provider operation, named after
+// the resource or data source type and the operation, e.g. "rpaas_block.create".
+func startOperationSpan(ctx context.Context, provider *rpaasProvider, resourceType, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	var opts *ProviderConfigOptions
+	if provider != nil {
+		opts = provider.opts
+	}
+
+	return tracerProvider(opts).Tracer(tracerName).Start(ctx, resourceType+"."+operation,
+		trace.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("rpaas.resource_type", resourceType),
+			attribute.String("rpaas.operation", operation),
+		}, attributes...)...),
+	)
+}
+
+// traceResourceOperations wraps the CRUD operations and the importer of
+// every resource with the span of the operation.
+func traceResourceOperations(resources map[string]*schema.Resource) {
+	for name, r := range resources {
+		r.CreateContext = traceResourceOperation(name, "create", r.CreateContext)
+		r.ReadContext = traceResourceOperation(name, "read", r.ReadContext)
+		r.UpdateContext = traceResourceOperation(name, "update", r.UpdateContext)
+		r.DeleteContext = traceResourceOperation(name, "delete", r.DeleteContext)
+
+		if r.Importer != nil && r.Importer.StateContext != nil {
+			r.Importer.StateContext = traceResourceImport(name, r.Importer.StateContext)
+		}
+	}
+}
+
+func traceResourceImport(resourceType string, fn schema.StateContextFunc) schema.StateContextFunc {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+		provider, _ := meta.(*rpaasProvider)
+		ctx, span := startOperationSpan(ctx, provider, resourceType, "import", attribute.String("rpaas.import_id", d.Id()))
+		defer span.End()
+
+		result, err := fn(ctx, d, meta)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return result, err
+	}
+}
+
+func traceResourceOperation[F ~func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics](resourceType, operation string, fn F) F {
+	if fn == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		provider, ok := meta.(*rpaasProvider)
+		if !ok {
+			return fn(ctx, d, meta)
+		}
+
+		ctx, span := startOperationSpan(ctx, provider, resourceType, operation,
+			attribute.String("rpaas.service", d.Get("service_name").(string)),
+			attribute.String("rpaas.instance", d.Get("instance").(string)),
+			attribute.String("rpaas.id", d.Id()),
+		)
+
+		diags := fn(ctx, d, meta)
+		for _, diagnostic := range diags {
+			if diagnostic.Severity == diag.Error {
+				span.SetStatus(codes.Error, diagnostic.Summary)
+				break
+			}
+		}
+
+		span.End()
+
+		return diags
+	}
+}
+
+var _ http.RoundTripper = (*tracingTransport)(nil)
+
+// tracingTransport creates a client span for every request sent to RPaaS
+// and Tsuru APIs, propagating the trace context to them.
+type tracingTransport struct {
+	TracerProvider trace.TracerProvider
+	Base           http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.TracerProvider.Tracer(tracerName).Start(r.Context(), "HTTP "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethod(r.Method),
+			semconv.HTTPURL(r.URL.Redacted()),
+		),
+	)
+	defer span.End()
+
+	r = r.Clone(ctx)
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	response, err := t.Base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return response, err
+	}
+
+	span.SetAttributes(semconv.HTTPStatusCode(response.StatusCode))
+	if response.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+	}
+
+	return response, nil
+}
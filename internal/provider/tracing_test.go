@@ -0,0 +1,239 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceResourceOperation(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"minReplicas": 1, "maxReplicas": 2}`))
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := &rpaasProvider{
+		opts: &ProviderConfigOptions{
+			TsuruTarget:    server.URL,
+			TsuruToken:     "my-token",
+			tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		},
+	}
+
+	var attempts int
+	create := traceResourceOperation("rpaas_autoscale", "create", schema.CreateContextFunc(func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		err := rpaasRetry(ctx, time.Minute, func() (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return nil, errors.New("event locked")
+			}
+
+			_, response, err := meta.(*rpaasProvider).Client("rpaasv2", "my-rpaas").RpaasApi.GetAutoscale(ctx, "my-rpaas").Execute()
+			return response, err
+		})
+		require.NoError(t, err)
+
+		return diag.Errorf("Unable to create autoscale for instance my-rpaas: boom")
+	}))
+
+	d := schema.TestResourceDataRaw(t, resourceRpaasAutoscale().Schema, map[string]interface{}{
+		"service_name": "rpaasv2",
+		"instance":     "my-rpaas",
+	})
+	diags := create(context.Background(), d, provider)
+	require.True(t, diags.HasError())
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	httpSpan, operationSpan := spans[0], spans[1]
+
+	assert.Equal(t, "rpaas_autoscale.create", operationSpan.Name)
+	assert.Equal(t, codes.Error, operationSpan.Status.Code)
+	assert.Equal(t, "Unable to create autoscale for instance my-rpaas: boom", operationSpan.Status.Description)
+	assert.Subset(t, operationSpan.Attributes, []attribute.KeyValue{
+		attribute.String("rpaas.resource_type", "rpaas_autoscale"),
+		attribute.String("rpaas.operation", "create"),
+		attribute.String("rpaas.service", "rpaasv2"),
+		attribute.String("rpaas.instance", "my-rpaas"),
+		attribute.Int("rpaas.retry_count", 1),
+	})
+	require.Len(t, operationSpan.Events, 1)
+	assert.Equal(t, "Retrying: tsuru event locked", operationSpan.Events[0].Name)
+
+	assert.Equal(t, "HTTP GET", httpSpan.Name)
+	assert.Equal(t, trace.SpanKindClient, httpSpan.SpanKind)
+	assert.Equal(t, operationSpan.SpanContext.SpanID(), httpSpan.Parent.SpanID())
+	assert.Equal(t, codes.Unset, httpSpan.Status.Code)
+	assert.Contains(t, httpSpan.Attributes, attribute.Int("http.status_code", http.StatusOK))
+	assert.Contains(t, httpSpan.Attributes, attribute.String("http.url", server.URL+"/1.0/services/rpaasv2/proxy/my-rpaas?callback=/resources/my-rpaas/autoscale"))
+
+	assert.Contains(t, traceparent, httpSpan.SpanContext.TraceID().String())
+}
+
+func TestTracingTransport_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	client := &http.Client{
+		Transport: &tracingTransport{
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+			Base:           http.DefaultTransport,
+		},
+	}
+
+	response, err := client.Post(server.URL+"/resources/my-rpaas/block", "text/plain", nil)
+	require.NoError(t, err)
+	response.Body.Close()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "HTTP POST", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Contains(t, spans[0].Attributes, attribute.Int("http.status_code", http.StatusServiceUnavailable))
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	tp, err := newTracerProvider(context.Background())
+	require.NoError(t, err)
+	_, isSDK := tp.(*sdktrace.TracerProvider)
+	assert.False(t, isSDK)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://127.0.0.1:4318")
+
+	tp, err = newTracerProvider(context.Background())
+	require.NoError(t, err)
+	assert.IsType(t, &sdktrace.TracerProvider{}, tp)
+}
+
+func TestTraceResourceImport(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := &rpaasProvider{
+		opts: &ProviderConfigOptions{tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))},
+	}
+
+	resources := map[string]*schema.Resource{
+		"rpaas_acl": {
+			Schema: resourceRpaasACL().Schema,
+			Importer: &schema.ResourceImporter{
+				StateContext: func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+					return nil, errors.New("Could not import rpaas_acl")
+				},
+			},
+		},
+	}
+	traceResourceOperations(resources)
+
+	d := resources["rpaas_acl"].TestResourceData()
+	d.SetId("rpaasv2::my-rpaas::example.com::80")
+	_, err := resources["rpaas_acl"].Importer.StateContext(context.Background(), d, provider)
+	require.Error(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "rpaas_acl.import", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Contains(t, spans[0].Attributes, attribute.String("rpaas.import_id", "rpaasv2::my-rpaas::example.com::80"))
+}
+
+func TestTraceDataSourceRead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	ds := &instanceStatusDataSource{
+		provider: &rpaasProvider{
+			opts: &ProviderConfigOptions{
+				URL:            server.URL,
+				tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+			},
+		},
+	}
+
+	ctx := context.Background()
+	var schemaResp datasource.SchemaResponse
+	ds.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+
+	objectType := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	attributes := make(map[string]tftypes.Value)
+	for name, attributeType := range objectType.AttributeTypes {
+		attributes[name] = tftypes.NewValue(attributeType, nil)
+	}
+	attributes["service_name"] = tftypes.NewValue(tftypes.String, "rpaasv2")
+	attributes["instance"] = tftypes.NewValue(tftypes.String, "my-rpaas")
+
+	req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schemaResp.Schema, Raw: tftypes.NewValue(objectType, attributes)}}
+	resp := datasource.ReadResponse{State: tfsdk.State{Schema: schemaResp.Schema}}
+	ds.Read(ctx, req, &resp)
+	require.True(t, resp.Diagnostics.HasError())
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "HTTP GET", spans[0].Name)
+	assert.Equal(t, "rpaas_instance_status.read", spans[1].Name)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+	assert.Contains(t, spans[1].Attributes, attribute.String("rpaas.instance", "my-rpaas"))
+}
+
+func TestShutdownTracing(t *testing.T) {
+	exporter := keepSpansExporter{tracetest.NewInMemoryExporter()}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+
+	sdkTracerProvidersMu.Lock()
+	sdkTracerProviders = append(sdkTracerProviders, tp)
+	sdkTracerProvidersMu.Unlock()
+
+	_, span := startOperationSpan(context.Background(), &rpaasProvider{opts: &ProviderConfigOptions{tracerProvider: tp}}, "rpaas_block", "create")
+	span.End()
+
+	// spans are batched, not exported after each operation
+	assert.Empty(t, exporter.GetSpans())
+
+	ShutdownTracing(context.Background())
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "rpaas_block.create", spans[0].Name)
+}
+
+// keepSpansExporter keeps the spans exported when shut down.
+type keepSpansExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (keepSpansExporter) Shutdown(context.Context) error {
+	return nil
+}
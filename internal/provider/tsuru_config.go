@@ -0,0 +1,213 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/go-tsuruclient/pkg/config"
+)
+
+// tsuruConfig reads the files written by the tsuru client (target, targets,
+// tokens) from its configuration directory, ~/.tsuru by default. It mirrors
+// the lookups done by go-tsuruclient, except that the target is always the
+// one chosen by the provider instead of the current tsuru client target.
+// go-tsuruclient's config package cannot be used for that: it always reads
+// $HOME/.tsuru and picks tokens for the current target only, so just its
+// token types are reused.
+type tsuruConfig struct {
+	dir string
+}
+
+func newTsuruConfig(dir string) (*tsuruConfig, error) {
+	if dir != "" {
+		return &tsuruConfig{dir: dir}, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("Could not find the tsuru config directory: %w", err)
+	}
+
+	return &tsuruConfig{dir: filepath.Join(home, ".tsuru")}, nil
+}
+
+// CurrentTarget returns the target set by "tsuru target set", or an empty
+// string when there is none.
+func (c *tsuruConfig) CurrentTarget() (string, error) {
+	target, err := c.readFile("target")
+	if err != nil {
+		return "", err
+	}
+
+	return c.ResolveTarget(strings.TrimSpace(target))
+}
+
+// ResolveTarget translates a target label (as in "tsuru target list") into
+// its URL. Any other value is returned as is.
+func (c *tsuruConfig) ResolveTarget(target string) (string, error) {
+	if target == "" || strings.Contains(target, "://") {
+		return target, nil
+	}
+
+	targets, err := c.targets()
+	if err != nil {
+		return "", err
+	}
+
+	if url, ok := targets[target]; ok {
+		return url, nil
+	}
+
+	return target, nil
+}
+
+// Token returns the token stored for target, preferring the token of that
+// specific target over the default one. The default token belongs to the
+// current tsuru client target, so it is only used when target is the current
+// one. It fails, naming the files checked, when there is no token.
+func (c *tsuruConfig) Token(ctx context.Context, target string) (string, error) {
+	label, err := c.label(target)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := c.CurrentTarget()
+	if err != nil {
+		return "", err
+	}
+
+	var tokenV2Paths, tokenV1Paths []string
+	if label != "" {
+		tokenV2Paths = append(tokenV2Paths, filepath.Join("token-v2.d", label+".json"))
+		tokenV1Paths = append(tokenV1Paths, filepath.Join("token.d", label))
+	}
+	if current != "" && sameTsuruTarget(current, target) {
+		tokenV2Paths = append(tokenV2Paths, "token-v2.json")
+		tokenV1Paths = append(tokenV1Paths, "token")
+	}
+
+	var checked []string
+	for _, p := range tokenV2Paths {
+		checked = append(checked, filepath.Join(c.dir, p))
+		content, err := c.readFile(p)
+		if err != nil {
+			return "", err
+		}
+
+		if content == "" {
+			continue
+		}
+
+		var token config.TokenV2
+		if err = json.Unmarshal([]byte(content), &token); err != nil {
+			return "", fmt.Errorf("Could not parse tsuru token %s: %w", filepath.Join(c.dir, p), err)
+		}
+
+		if token.Scheme != "oidc" || token.OAuth2Config == nil || token.OAuth2Token == nil {
+			continue
+		}
+
+		// refreshed tokens are not written back, the tsuru client does it
+		// on its next use
+		t, err := token.OAuth2Config.TokenSource(ctx, token.OAuth2Token).Token()
+		if err != nil {
+			return "", fmt.Errorf("Could not refresh tsuru token %s: %w", filepath.Join(c.dir, p), err)
+		}
+
+		return t.AccessToken, nil
+	}
+
+	for _, p := range tokenV1Paths {
+		checked = append(checked, filepath.Join(c.dir, p))
+		token, err := c.readFile(p)
+		if err != nil {
+			return "", err
+		}
+
+		if token = strings.TrimSpace(token); token != "" {
+			return token, nil
+		}
+	}
+
+	if len(checked) == 0 {
+		return "", fmt.Errorf("Tsuru token not found for target %s: it is neither the current tsuru target nor has a label in %s. Set tsuru_token, tsuru_token_command or tsuru_token_file", target, filepath.Join(c.dir, "targets"))
+	}
+
+	return "", fmt.Errorf("Tsuru token not found for target %s (looked into %s). Set tsuru_token, tsuru_token_command or tsuru_token_file, or run \"tsuru login\"", target, strings.Join(checked, ", "))
+}
+
+func (c *tsuruConfig) label(target string) (string, error) {
+	targets, err := c.targets()
+	if err != nil {
+		return "", err
+	}
+
+	var labels []string
+	for label, url := range targets {
+		if sameTsuruTarget(url, target) {
+			labels = append(labels, label)
+		}
+	}
+
+	if len(labels) == 0 {
+		return "", nil
+	}
+
+	// same choice as the tsuru client when many labels share the target
+	sort.Strings(labels)
+	return labels[0], nil
+}
+
+func (c *tsuruConfig) targets() (map[string]string, error) {
+	content, err := c.readFile("targets")
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		parts := strings.Split(line, "\t")
+		if len(parts) == 2 {
+			targets[parts[0]] = parts[1]
+		}
+	}
+
+	return targets, nil
+}
+
+// readFile returns the content of a file inside the config directory, or an
+// empty string when it does not exist.
+func (c *tsuruConfig) readFile(name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(c.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Could not read tsuru config: %w", err)
+	}
+
+	return string(content), nil
+}
+
+func sameTsuruTarget(a, b string) bool {
+	normalize := func(target string) string {
+		if !strings.Contains(target, "://") {
+			target = "http://" + target
+		}
+		return strings.TrimRight(target, "/")
+	}
+
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetProviderConfigOpts_TsuruTarget(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         map[string]interface{}
+		env            map[string]string
+		files          map[string]string
+		configDirFiles map[string]string
+		expectedTarget string
+		expectedToken  string
+		expectedError  string
+	}{
+		{
+			name:          "nothing set",
+			expectedError: "Tsuru target not found: either set rpaas_url or tsuru_target",
+		},
+		{
+			name:   "rpaas_url does not need a target",
+			config: map[string]interface{}{"rpaas_url": "http://rpaas.example.com"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+		},
+		{
+			name: "target and token from the tsuru config files",
+			files: map[string]string{
+				"target": "https://tsuru.example.com\n",
+				"token":  "file-token\n",
+			},
+			expectedTarget: "https://tsuru.example.com",
+			expectedToken:  "file-token",
+		},
+		{
+			name:   "explicit target is not overwritten by the tsuru config files",
+			config: map[string]interface{}{"tsuru_target": "https://other.example.com", "tsuru_token": "my-token"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+			expectedTarget: "https://other.example.com",
+			expectedToken:  "my-token",
+		},
+		{
+			name:   "explicit target does not use the current target token",
+			config: map[string]interface{}{"tsuru_target": "https://other.example.com"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+			expectedError: "Tsuru token not found for target https://other.example.com: it is neither the current tsuru target nor has a label in <home>/.tsuru/targets",
+		},
+		{
+			name:   "explicit target is the current target",
+			config: map[string]interface{}{"tsuru_target": "https://tsuru.example.com/"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+			expectedTarget: "https://tsuru.example.com/",
+			expectedToken:  "file-token",
+		},
+		{
+			name:   "target labels without their own token do not use the current target token",
+			config: map[string]interface{}{"tsuru_target": "prod"},
+			files: map[string]string{
+				"target":  "dev",
+				"targets": "dev\thttps://dev.example.com\nprod\thttps://prod.example.com\n",
+				"token":   "dev-token",
+			},
+			expectedError: "Tsuru token not found for target https://prod.example.com (looked into <home>/.tsuru/token-v2.d/prod.json, <home>/.tsuru/token.d/prod)",
+		},
+		{
+			name:   "current target without a token",
+			config: map[string]interface{}{"tsuru_target": "https://tsuru.example.com"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+			},
+			expectedError: "Tsuru token not found for target https://tsuru.example.com (looked into <home>/.tsuru/token-v2.json, <home>/.tsuru/token)",
+		},
+		{
+			name:           "explicit target without tsuru config files",
+			config:         map[string]interface{}{"tsuru_target": "https://tsuru.example.com", "tsuru_token": "my-token"},
+			expectedTarget: "https://tsuru.example.com",
+			expectedToken:  "my-token",
+		},
+		{
+			name: "env target takes precedence over the tsuru config files",
+			env:  map[string]string{"TSURU_TARGET": "https://env.example.com", "TSURU_TOKEN": "env-token"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+			expectedTarget: "https://env.example.com",
+			expectedToken:  "env-token",
+		},
+		{
+			name:           "explicit attributes take precedence over env",
+			config:         map[string]interface{}{"tsuru_target": "https://other.example.com", "tsuru_token": "my-token"},
+			env:            map[string]string{"TSURU_TARGET": "https://env.example.com", "TSURU_TOKEN": "env-token"},
+			expectedTarget: "https://other.example.com",
+			expectedToken:  "my-token",
+		},
+		{
+			name:   "target labels are resolved and use their own token",
+			config: map[string]interface{}{"tsuru_target": "prod"},
+			files: map[string]string{
+				"target":       "https://dev.example.com",
+				"targets":      "dev\thttps://dev.example.com\nprod\thttps://prod.example.com\n",
+				"token":        "dev-token",
+				"token.d/prod": "prod-token",
+			},
+			expectedTarget: "https://prod.example.com",
+			expectedToken:  "prod-token",
+		},
+		{
+			name: "current target label",
+			files: map[string]string{
+				"target":      "dev",
+				"targets":     "dev\thttps://dev.example.com\nprod\thttps://prod.example.com\n",
+				"token":       "default-token",
+				"token.d/dev": "dev-token",
+			},
+			expectedTarget: "https://dev.example.com",
+			expectedToken:  "dev-token",
+		},
+		{
+			name:   "tsuru_config_dir overrides ~/.tsuru",
+			config: map[string]interface{}{"tsuru_config_dir": "<config-dir>"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+				"token":  "file-token",
+			},
+			configDirFiles: map[string]string{
+				"target": "https://custom.example.com",
+				"token":  "custom-token",
+			},
+			expectedTarget: "https://custom.example.com",
+			expectedToken:  "custom-token",
+		},
+		{
+			name:   "tsuru_config_dir without a target",
+			config: map[string]interface{}{"tsuru_config_dir": "<config-dir>"},
+			files: map[string]string{
+				"target": "https://tsuru.example.com",
+			},
+			expectedError: "Tsuru target not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			configDir := t.TempDir()
+
+			for _, env := range []string{"RPAAS_URL", "RPAAS_USER", "RPAAS_PASSWORD", "TSURU_TARGET", "TSURU_TOKEN", "TSURU_TOKEN_COMMAND", "TSURU_TOKEN_FILE", "TSURU_CONFIG_DIR"} {
+				t.Setenv(env, "")
+			}
+			t.Setenv("HOME", home)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			writeTestFiles(t, filepath.Join(home, ".tsuru"), tt.files)
+			writeTestFiles(t, configDir, tt.configDirFiles)
+
+			raw := map[string]interface{}{}
+			for k, v := range tt.config {
+				if v == "<config-dir>" {
+					v = configDir
+				}
+				raw[k] = v
+			}
+
+			d := schema.TestResourceDataRaw(t, Provider().Schema, raw)
+			opts, err := getProviderConfigOpts(context.Background(), d)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, strings.ReplaceAll(tt.expectedError, "<home>", home))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedTarget, opts.TsuruTarget)
+			assert.Equal(t, tt.expectedToken, opts.TsuruToken)
+		})
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+}
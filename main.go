@@ -4,7 +4,10 @@
 package main
 
 import (
-	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+	"context"
+	"log"
+
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6/tf6server"
 
 	"github.com/tsuru/terraform-provider-rpaas/internal/provider"
 )
@@ -12,7 +15,16 @@ import (
 //go:generate tfplugindocs
 
 func main() {
-	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: provider.Provider,
-	})
+	ctx := context.Background()
+
+	providerServer, err := provider.ProviderServer(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = tf6server.Serve("registry.terraform.io/tsuru/rpaas", providerServer)
+	provider.ShutdownTracing(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
 }